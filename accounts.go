@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/eximchain/go-ethereum/accounts"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// EthAccounts lists the accounts the executor can sign with, followed by the
+// node's own accounts if nodeAccounts is set
+func (svc transactionExecutorService) EthAccounts(ctx context.Context, params interface{}) (interface{}, error) {
+	addresses, err := svc.PersonalListAccounts(ctx)
+	if err != nil || !svc.nodeAccounts {
+		return addresses, err
+	}
+
+	u, _ := url.Parse(svc.quorumAddress)
+	client := jsonrpc.NewClient(u, "eth_accounts")
+	res, err := client.Endpoint()(ctx, params)
+	if err != nil {
+		return nil, nodeError(ErrQuorum, err)
+	}
+
+	nodeAddresses, _ := res.([]interface{})
+	for _, a := range nodeAddresses {
+		address, ok := a.(string)
+		if ok && !containsAddress(addresses, address) {
+			addresses = append(addresses, strings.ToLower(address))
+		}
+	}
+
+	return addresses, nil
+}
+
+// visibleAccounts filters the signer's addresses to those the authenticated
+// user may use if accountsPerUser is set. Without authentication every
+// account is visible.
+func (svc transactionExecutorService) visibleAccounts(ctx context.Context, addresses []string) ([]string, error) {
+	user := userFromContext(ctx)
+	if !svc.accountsPerUser || user == "" || svc.db == nil {
+		return addresses, nil
+	}
+
+	allowed, err := svc.db.userAccounts(user)
+	if err != nil {
+		log.Println("Error: userAccounts")
+		log.Println(err)
+		return nil, ErrDatabase
+	}
+
+	visible := []string{}
+	for _, address := range addresses {
+		if containsAddress(allowed, address) {
+			visible = append(visible, address)
+		}
+	}
+
+	return visible, nil
+}
+
+// userAccount looks up an account the authenticated user may sign with.
+// Without authentication every account may be used.
+func (svc transactionExecutorService) userAccount(ctx context.Context, address string) (accounts.Account, error) {
+	account, err := svc.account(address)
+	if err != nil {
+		return account, err
+	}
+
+	user := userFromContext(ctx)
+	if user == "" || svc.db == nil {
+		return account, nil
+	}
+
+	allowed, err := svc.db.accountAllowed(address, user)
+	if err != nil {
+		log.Println("Error: accountAllowed")
+		log.Println(err)
+		return accounts.Account{}, ErrDatabase
+	}
+	if !allowed {
+		return accounts.Account{}, ErrAccountNotAllowed
+	}
+
+	return account, nil
+}
+
+// recordAccountUser lets the authenticated user, if any, use a new account
+func (svc transactionExecutorService) recordAccountUser(ctx context.Context, address string) {
+	user := userFromContext(ctx)
+	if user == "" || svc.db == nil {
+		return
+	}
+
+	if err := svc.db.addAccountUser(address, user); err != nil {
+		log.Println("Error: recording account user", address, err)
+	}
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrDatabase is returned when the executor's database cannot be read or written
+var ErrDatabase = newRPCError(ErrCodeServer, "error using database")
+
+// ErrAccountNotAllowed is returned when the authenticated user has not created
+// or been granted the account
+var ErrAccountNotAllowed = newRPCError(ErrCodeServer, "account not allowed for this user")
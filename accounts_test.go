@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAccountsPerUser(t *testing.T) {
+	svc, _ := NewTestService()
+	db := NewTestDB()
+	defer db.close()
+	svc.db = db
+	svc.accountsPerUser = true
+
+	alice := context.WithValue(context.Background(), userContextKey, "alice@example.com")
+	bob := context.WithValue(context.Background(), userContextKey, "bob@example.com")
+
+	aliceAccount, err := svc.PersonalNewAccount(alice, "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+	bobAccount, err := svc.PersonalNewAccount(bob, "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	for ctx, expected := range map[context.Context][]string{
+		alice: {aliceAccount},
+		bob:   {bobAccount},
+		context.WithValue(context.Background(), userContextKey, "eve@example.com"): {},
+	} {
+		accounts, err := svc.EthAccounts(ctx, []interface{}{})
+		if err != nil || !reflect.DeepEqual(accounts, expected) {
+			t.Fatalf("expected %v, got %v %v", expected, accounts, err)
+		}
+	}
+
+	// Without authentication every account is listed
+	accounts, err := svc.EthAccounts(context.Background(), []interface{}{})
+	if err != nil || len(accounts.([]string)) != 2 {
+		t.Fatalf("expected both accounts, got %v %v", accounts, err)
+	}
+}
+
+func TestNodeAccounts(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":["0x00000000000000000000000000000000000000AA"]}`))
+	}))
+	defer node.Close()
+
+	svc, _ := NewTestService()
+	svc.quorumAddress = node.URL
+	address, err := svc.PersonalNewAccount(context.Background(), "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	accounts, err := svc.EthAccounts(context.Background(), []interface{}{})
+	if err != nil || !reflect.DeepEqual(accounts, []string{address}) {
+		t.Fatalf("expected only the signer's account, got %v %v", accounts, err)
+	}
+
+	svc.nodeAccounts = true
+	accounts, err = svc.EthAccounts(context.Background(), []interface{}{})
+	if err != nil || !reflect.DeepEqual(accounts, []string{address, strings.ToLower("0x00000000000000000000000000000000000000AA")}) {
+		t.Fatalf("expected the node's account too, got %v %v", accounts, err)
+	}
+}
+
+func TestAccountOwnership(t *testing.T) {
+	svc, _ := NewTestService()
+	db := NewTestDB()
+	defer db.close()
+	svc.db = db
+
+	alice := context.WithValue(context.Background(), userContextKey, "alice@example.com")
+	bob := context.WithValue(context.Background(), userContextKey, "bob@example.com")
+
+	address, err := svc.PersonalNewAccount(alice, "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	to := "0x0000000000000000000000000000000000000001"
+	if _, err := svc.EthSign(alice, address, "0x1234"); err != nil {
+		t.Fatalf("expected the creator to sign, got %s", err)
+	}
+	if _, err := svc.EthSign(bob, address, "0x1234"); err != ErrAccountNotAllowed {
+		t.Fatalf("expected %s, got %v", ErrAccountNotAllowed, err)
+	}
+	if _, err := svc.EthSignTransaction(bob, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err != ErrAccountNotAllowed {
+		t.Fatalf("expected %s, got %v", ErrAccountNotAllowed, err)
+	}
+	if _, err := svc.ExecuteTransaction(bob, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err != ErrAccountNotAllowed {
+		t.Fatalf("expected %s, got %v", ErrAccountNotAllowed, err)
+	}
+
+	var out bytes.Buffer
+	db.deleteUser("bob@example.com")
+	runUserCommand(db, &out, []string{"-email", "bob@example.com", "-grant", address})
+	if out.String() != "bob@example.com not found\n" {
+		t.Fatalf("expected granting to a missing user to fail, got %q", out.String())
+	}
+
+	if _, err := db.createUser("bob@example.com", RoleSigner, nil); err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+	defer db.deleteUser("bob@example.com")
+
+	out.Reset()
+	runUserCommand(db, &out, []string{"-email", "bob@example.com", "-grant", address})
+	if out.String() != "bob@example.com granted "+address+"\n" {
+		t.Fatalf("unexpected grant output %q", out.String())
+	}
+	if _, err := svc.EthSign(bob, address, "0x1234"); err != nil {
+		t.Fatalf("expected a granted user to sign, got %s", err)
+	}
+
+	out.Reset()
+	runUserCommand(db, &out, []string{"-email", "bob@example.com", "-revoke", address})
+	if out.String() != "bob@example.com revoked "+address+"\n" {
+		t.Fatalf("unexpected revoke output %q", out.String())
+	}
+	if _, err := svc.EthSign(bob, address, "0x1234"); err != ErrAccountNotAllowed {
+		t.Fatalf("expected %s after revoking, got %v", ErrAccountNotAllowed, err)
+	}
+
+	// Without authentication every account may be used
+	if _, err := svc.EthSign(context.Background(), address, "0x1234"); err != nil {
+		t.Fatalf("expected unauthenticated signing, got %s", err)
+	}
+}
@@ -1,34 +1,212 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	// Holds the authenticated User, whose role limits the methods called
+	permissionsContextKey
+)
+
+// Roles give a user's token access to classes of methods. A role of
+// RoleCustom only allows the user's own method list.
+const (
+	RoleReadOnly = "read-only"
+	RoleSigner   = "signer"
+	RoleAdmin    = "admin"
+	RoleCustom   = "custom"
+)
+
+// Method classes, from least to most privileged
+const (
+	methodClassRead = iota
+	methodClassSign
+	methodClassAdmin
 )
 
+// readMethods are handled by the executor and only read from the node, the
+// journal or the signer's account list
+var readMethods = map[string]bool{
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+	"net_version":                             true,
+	"net_peerCount":                           true,
+	"net_listening":                           true,
+	"eth_protocolVersion":                     true,
+	"eth_syncing":                             true,
+	"eth_coinbase":                            true,
+	"eth_mining":                              true,
+	"eth_hashrate":                            true,
+	"eth_gasPrice":                            true,
+	"eth_accounts":                            true,
+	"eth_blockNumber":                         true,
+	"eth_getBalance":                          true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionCount":                 true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getUncleCountByBlockHash":            true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_getCode":                             true,
+	"eth_call":                                true,
+	"eth_estimateGas":                         true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getUncleByBlockHashAndIndex":         true,
+	"eth_getUncleByBlockNumberAndIndex":       true,
+	"eth_newFilter":                           true,
+	"eth_newBlockFilter":                      true,
+	"eth_newPendingTransactionFilter":         true,
+	"eth_uninstallFilter":                     true,
+	"eth_getFilterChanges":                    true,
+	"eth_getFilterLogs":                       true,
+	"eth_getLogs":                             true,
+	"eth_getWork":                             true,
+	"eth_subscribe":                           true,
+	"eth_unsubscribe":                         true,
+	"personal_listAccounts":                   true,
+	"personal_ecRecover":                      true,
+	"executor_getTransaction":                 true,
+	"executor_listTransactions":               true,
+}
+
+// signingMethods use the executor's accounts to sign or send transactions
+var signingMethods = map[string]bool{
+	"eth_sendTransaction":         true,
+	"eth_sendRawTransaction":      true,
+	"eth_sign":                    true,
+	"eth_signTransaction":         true,
+	"eth_signTypedData_v3":        true,
+	"eth_signTypedData_v4":        true,
+	"personal_sign":               true,
+	"personal_unlockAccount":      true,
+	"personal_lockAccount":        true,
+	"executor_cancelTransaction":  true,
+	"executor_speedUpTransaction": true,
+}
+
+// methodClass returns the class of a method. Methods the executor does not
+// know, including those passed through to the node, need the admin role
+// unless the user is allowed them by name.
+func methodClass(method string) int {
+	if readMethods[method] {
+		return methodClassRead
+	}
+	if signingMethods[method] {
+		return methodClassSign
+	}
+
+	return methodClassAdmin
+}
+
+// roleClass returns the most privileged method class a role may call, or -1
+// if it allows none. Users stored before roles existed are given one when
+// the database is opened, so an empty role allows nothing.
+func roleClass(role string) int {
+	switch role {
+	case RoleReadOnly:
+		return methodClassRead
+	case RoleSigner:
+		return methodClassSign
+	case RoleAdmin:
+		return methodClassAdmin
+	}
+
+	return -1
+}
+
+func isRole(role string) bool {
+	return role == RoleReadOnly || role == RoleSigner || role == RoleAdmin || role == RoleCustom
+}
+
+// userFromContext returns the email of the authenticated user, if any
+func userFromContext(ctx context.Context) string {
+	email, _ := ctx.Value(userContextKey).(string)
+	return email
+}
+
+// isAdmin reports whether the authenticated user has the admin role
+func isAdmin(ctx context.Context) bool {
+	user, ok := ctx.Value(permissionsContextKey).(User)
+	return ok && roleClass(user.Role) == methodClassAdmin
+}
+
+// authorizeMethod checks that the authenticated user may call a method and
+// is within their rate limit. Without authentication every method may be
+// called.
+func authorizeMethod(ctx context.Context, method string) error {
+	user, ok := ctx.Value(permissionsContextKey).(User)
+	if !ok {
+		return nil
+	}
+
+	if methodClass(method) > roleClass(user.Role) && !matchMethod(user.Methods, method) {
+		return ErrMethodNotAllowed.derive("method "+method+" not allowed for role "+user.Role, nil)
+	}
+
+	return limitMethod(user, method)
+}
+
+// authorized checks the caller's permissions before calling an endpoint
+func authorized(method string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if err := authorizeMethod(ctx, method); err != nil {
+			return nil, err
+		}
+
+		return next(ctx, request)
+	}
+}
+
 func Auth(db *BoltDB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 
+		// Browsers cannot set headers on websocket upgrades
+		if auth == "" && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			auth = r.URL.Query().Get("token")
+		}
+
 		if auth == "" {
 			http.Error(w, "no auth in header", http.StatusUnauthorized)
 			return
 		}
 
-		email, err := db.getUser(auth)
+		user, err := db.getUser(auth)
+
+		if err == errTokenExpired {
+			http.Error(w, "token expired", http.StatusUnauthorized)
+			return
+		}
 
 		if err != nil {
 			http.Error(w, "no user found", http.StatusUnauthorized)
 			return
 		}
 
-		if email == "" {
+		if user.Email == "" {
 			http.Error(w, "no user found", http.StatusUnauthorized)
 			return
 		}
 
-		log.Println(email, auth)
+		log.Println(user.Email, tokenPrefix(auth))
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), userContextKey, user.Email)
+		ctx = context.WithValue(ctx, permissionsContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -37,3 +215,6 @@ func DisableAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ErrMethodNotAllowed is returned when the user's role does not allow a method
+var ErrMethodNotAllowed = newRPCError(ErrCodeMethodNotSupported, "method not allowed")
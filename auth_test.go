@@ -2,14 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"golang.org/x/net/websocket"
 )
 
-func testRpc(t *testing.T, token string) *http.Response {
+func testRpc(t *testing.T, url string, token string) *http.Response {
 	jsonStr := []byte(`{"jsonrpc":"2.0","id":2,"method":"eth_syncing","params":[]}`)
-	req, err := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonStr))
 	if err != nil {
 		t.Fatalf("NewRequest %v", err)
 	}
@@ -24,8 +35,47 @@ func testRpc(t *testing.T, token string) *http.Response {
 	return resp
 }
 
+// NewTempDB opens an empty database that is removed by the returned function
+func NewTempDB(t *testing.T) (*BoltDB, func()) {
+	f, err := ioutil.TempFile(filepath.Dir(os.Args[0]), "eximchain_temp_*.db")
+	if err != nil {
+		t.Fatalf("cannot create database file %s", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	db := &BoltDB{}
+	if err := db.open(filepath.Base(f.Name())); err != nil {
+		t.Fatalf("cannot open database %s", err)
+	}
+
+	return db, func() {
+		db.close()
+		os.Remove(f.Name())
+	}
+}
+
+// newAuthServer serves the RPC handler behind Auth, with eth_syncing
+// answered by a fake node
+func newAuthServer(t *testing.T, db *BoltDB) (*httptest.Server, func()) {
+	node := newFakeNode(t)
+	svc, _ := NewTestService()
+	svc.quorumAddress = node.URL
+
+	srv := httptest.NewServer(Auth(db, MakeRPCHandler(svc, 2)))
+	return srv, func() {
+		srv.Close()
+		node.Close()
+	}
+}
+
 func TestHttpAuthXfail(t *testing.T) {
-	resp := testRpc(t, "asdf")
+	db, done := NewTempDB(t)
+	defer done()
+	srv, stop := newAuthServer(t, db)
+	defer stop()
+
+	resp := testRpc(t, srv.URL, "asdf")
 	defer resp.Body.Close()
 	if resp.Status != "401 Unauthorized" {
 		t.Fatalf("response Status: %v, expected 401 Unauthorized", resp.Status)
@@ -33,19 +83,202 @@ func TestHttpAuthXfail(t *testing.T) {
 }
 
 func TestHttpAuth(t *testing.T) {
-	// Grab the first user token from the server over IPC
-	args := []string{"--list"}
-	output := sendIPC(args)
-	fields := strings.Fields(output)
-	if len(fields) < 2 {
-		t.Fatal("No users in database; please add at least one token.")
+	db, done := NewTempDB(t)
+	defer done()
+	srv, stop := newAuthServer(t, db)
+	defer stop()
+
+	// Tokens are only shown when created, so create a user as the IPC
+	// server would
+	client, server := net.Pipe()
+	go ipcServer(db, server)
+	if err := gob.NewEncoder(client).Encode([]string{"--email", "httpauth@example.com", "--update"}); err != nil {
+		t.Fatalf("cannot send command %s", err)
+	}
+	var reply ipcReply
+	if err := gob.NewDecoder(client).Decode(&reply); err != nil {
+		t.Fatalf("cannot read reply %s", err)
+	}
+	fields := strings.Fields(reply.Output)
+	if reply.Failed || len(fields) < 2 {
+		t.Fatalf("cannot create a token, got %+v", reply)
 	}
 
-	token := fields[1]
-	t.Logf("Using token %s", token)
-	resp := testRpc(t, token)
+	resp := testRpc(t, srv.URL, fields[1])
 	defer resp.Body.Close()
 	if resp.Status != "200 OK" {
 		t.Fatalf("response Status: %v, expected 200 OK", resp.Status)
 	}
 }
+
+func TestMethodRoles(t *testing.T) {
+	for _, c := range []struct {
+		user    User
+		method  string
+		allowed bool
+	}{
+		{User{Role: RoleReadOnly}, "eth_getBalance", true},
+		{User{Role: RoleReadOnly}, "eth_sendTransaction", false},
+		{User{Role: RoleReadOnly, Methods: []string{"eth_sign*"}}, "eth_signTypedData_v4", true},
+		{User{Role: RoleSigner}, "eth_sendTransaction", true},
+		{User{Role: RoleSigner}, "personal_newAccount", false},
+		{User{Role: RoleAdmin}, "personal_importRawKey", true},
+		{User{}, "personal_newAccount", false},
+		{User{}, "eth_getBalance", false},
+		{User{Role: RoleCustom, Methods: []string{"eth_call"}}, "eth_call", true},
+		{User{Role: RoleCustom, Methods: []string{"eth_call"}}, "eth_blockNumber", false},
+		// Methods the executor does not know need the admin role or a listing
+		{User{Role: RoleReadOnly}, "eth_sendTransactionAsync", false},
+		{User{Role: RoleReadOnly}, "eth_sendRawPrivateTransaction", false},
+		{User{Role: RoleSigner}, "raft_addPeer", false},
+		{User{Role: RoleReadOnly}, "txpool_content", false},
+		{User{Role: RoleReadOnly, Methods: []string{"txpool_*"}}, "txpool_content", true},
+		{User{Role: RoleAdmin}, "txpool_content", true},
+	} {
+		ctx := context.WithValue(context.Background(), permissionsContextKey, c.user)
+		if err := authorizeMethod(ctx, c.method); (err == nil) != c.allowed {
+			t.Fatalf("expected %s allowed %v for %+v, got %v", c.method, c.allowed, c.user, err)
+		}
+	}
+
+	if err := authorizeMethod(context.Background(), "personal_newAccount"); err != nil {
+		t.Fatalf("expected every method without authentication, got %s", err)
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	db.deleteUser("newsigner@example.com")
+	db.deleteUser("newreader@example.com")
+	defer db.deleteUser("newsigner@example.com")
+	defer db.deleteUser("newreader@example.com")
+
+	var out bytes.Buffer
+	runUserCommand(db, &out, []string{"-email", "newsigner@example.com", "-update"})
+	if user, err := db.getUserByEmail("newsigner@example.com"); err != nil || user.Role != RoleSigner {
+		t.Fatalf("expected a new user to be a signer, got %+v %v", user, err)
+	}
+
+	runUserCommand(db, &out, []string{"-email", "newreader@example.com", "-update", "-role", RoleReadOnly})
+	if user, err := db.getUserByEmail("newreader@example.com"); err != nil || user.Role != RoleReadOnly {
+		t.Fatalf("expected the given role, got %+v %v", user, err)
+	}
+
+	// Rotating a token keeps the role unless another is given
+	runUserCommand(db, &out, []string{"-email", "newreader@example.com", "-update"})
+	if user, err := db.getUserByEmail("newreader@example.com"); err != nil || user.Role != RoleReadOnly {
+		t.Fatalf("expected the role to be kept, got %+v %v", user, err)
+	}
+	runUserCommand(db, &out, []string{"-email", "newreader@example.com", "-update", "-role", RoleAdmin})
+	if user, err := db.getUserByEmail("newreader@example.com"); err != nil || user.Role != RoleAdmin {
+		t.Fatalf("expected the role to change, got %+v %v", user, err)
+	}
+}
+
+func TestAuthRoles(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	db.deleteUser("reader@example.com")
+	token, err := db.createUser("reader@example.com", RoleReadOnly, nil)
+	if err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+	defer db.deleteUser("reader@example.com")
+
+	svc, _ := NewTestService()
+	h := Auth(db, MakeRPCHandler(svc, 2))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", token)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	var res testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"personal_listAccounts","params":[]}`), &res)
+	if res.Error != nil {
+		t.Fatalf("expected a read-only user to list accounts, got %+v", res.Error)
+	}
+
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"personal_newAccount","params":[""]}`), &res)
+	if res.Error == nil || res.Error.Code != ErrCodeMethodNotSupported {
+		t.Fatalf("expected method not allowed, got %+v", res)
+	}
+
+	var batch []testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, `[{"jsonrpc":"2.0","id":1,"method":"eth_sign","params":["0x0000000000000000000000000000000000000001","0x12"]}]`), &batch)
+	if len(batch) != 1 || batch[0].Error == nil || batch[0].Error.Code != ErrCodeMethodNotSupported {
+		t.Fatalf("expected method not allowed in a batch, got %+v", batch)
+	}
+}
+
+func TestUserCommandFailures(t *testing.T) {
+	db, done := NewTempDB(t)
+	defer done()
+
+	send := func(args ...string) ipcReply {
+		client, server := net.Pipe()
+		defer client.Close()
+		go ipcServer(db, server)
+		if err := gob.NewEncoder(client).Encode(args); err != nil {
+			t.Fatalf("cannot send command %s", err)
+		}
+		var reply ipcReply
+		if err := gob.NewDecoder(client).Decode(&reply); err != nil {
+			t.Fatalf("cannot read reply %s", err)
+		}
+		return reply
+	}
+
+	if reply := send("-email", "nobody@example.com", "-grant", "0x00000000000000000000000000000000000000aa"); !reply.Failed || reply.Output != "nobody@example.com not found\n" {
+		t.Fatalf("expected the grant to fail, got %+v", reply)
+	}
+	if reply := send("-email", "failures@example.com", "-update"); reply.Failed {
+		t.Fatalf("expected the user to be created, got %+v", reply)
+	}
+
+	// Database errors go to the client rather than the server's log
+	db.close()
+	if reply := send("-list"); !reply.Failed || !strings.HasPrefix(reply.Output, "cannot list users:") {
+		t.Fatalf("expected the database error in the reply, got %+v", reply)
+	}
+}
+
+func TestWebsocketQueryToken(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	db.deleteUser("wsreader@example.com")
+	token, err := db.createUser("wsreader@example.com", RoleSigner, nil)
+	if err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+	defer db.deleteUser("wsreader@example.com")
+
+	svc, _ := NewTestService()
+	srv := httptest.NewServer(Auth(db, MakeWSHandler(svc, 2, wsConfig{})))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, err := websocket.Dial(wsURL+"?token=nope", "", srv.URL); err == nil {
+		t.Fatal("expected an unknown token to be rejected")
+	}
+
+	ws, err := websocket.Dial(wsURL+"?token="+url.QueryEscape(token), "", srv.URL)
+	if err != nil {
+		t.Fatalf("expected the query token to authenticate, got %s", err)
+	}
+	ws.Close()
+
+	// The query parameter is only read for websocket upgrades
+	res, err := http.Post(srv.URL+"?token="+url.QueryEscape(token), "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_syncing","params":[]}`))
+	if err != nil {
+		t.Fatalf("cannot post %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a header, got %s", res.Status)
+	}
+}
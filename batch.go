@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultBatchConcurrency = 8
+
+// batchSequentialMethods change state and are run one at a time in the order
+// they appear in a batch, so that e.g. nonces follow request order
+var batchSequentialMethods = map[string]bool{
+	"eth_sendTransaction":         true,
+	"eth_sendRawTransaction":      true,
+	"eth_signTransaction":         true,
+	"personal_newAccount":         true,
+	"personal_importRawKey":       true,
+	"personal_unlockAccount":      true,
+	"personal_lockAccount":        true,
+	"executor_cancelTransaction":  true,
+	"executor_speedUpTransaction": true,
+	// Websocket subscriptions are counted against a per-connection limit
+	"eth_subscribe":   true,
+	"eth_unsubscribe": true,
+}
+
+// batchHandler passes single requests to the go-kit server and serves JSON
+// arrays of requests by dispatching each through the same codec map.
+// Methods missing from the map go to fallback if it is set.
+type batchHandler struct {
+	ecm         jsonrpc.EndpointCodecMap
+	server      http.Handler
+	concurrency int
+	fallback    func(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error)
+}
+
+func newBatchHandler(ecm jsonrpc.EndpointCodecMap, server http.Handler, concurrency int) *batchHandler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &batchHandler{ecm: ecm, server: server, concurrency: concurrency}
+}
+
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.server.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeRPCResponse(w, errorResponse(nil, jsonrpc.Error{Code: jsonrpc.ParseError, Message: "cannot read request body"}))
+		return
+	}
+
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		if req, ok := h.fallbackRequest(body); ok {
+			res, err := h.callRequest(r.Context(), req)
+			if overLimit(err) {
+				w.Header().Set("Content-Type", jsonrpc.ContentType)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+			if req.ID != nil {
+				writeRPCResponse(w, res)
+			}
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.server.ServeHTTP(w, r)
+		return
+	}
+
+	var reqs []json.RawMessage
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		writeRPCResponse(w, errorResponse(nil, jsonrpc.Error{Code: jsonrpc.ParseError, Message: "JSON could not be decoded: " + err.Error()}))
+		return
+	}
+
+	if len(reqs) == 0 {
+		writeRPCResponse(w, errorResponse(nil, jsonrpc.Error{Code: jsonrpc.InvalidRequestError, Message: "empty batch"}))
+		return
+	}
+
+	responses := h.serveBatch(r.Context(), reqs, h.serveRequest)
+
+	// A batch of notifications gets no response at all
+	if len(responses) == 0 {
+		return
+	}
+
+	writeRPCResponse(w, responses)
+}
+
+// serveBatch serves each request of a batch with serve and returns the
+// responses in request order, leaving out notifications
+func (h *batchHandler) serveBatch(ctx context.Context, reqs []json.RawMessage, serve func(context.Context, *jsonrpc.Request) *jsonrpc.Response) []*jsonrpc.Response {
+	responses := make([]*jsonrpc.Response, len(reqs))
+	sequential := []int{}
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+
+	parsed := make([]jsonrpc.Request, len(reqs))
+	for i, raw := range reqs {
+		req := &parsed[i]
+		if err := json.Unmarshal(raw, req); err != nil || req.Method == "" {
+			responses[i] = errorResponse(nil, jsonrpc.Error{Code: jsonrpc.InvalidRequestError, Message: "invalid request"})
+			continue
+		}
+
+		if batchSequentialMethods[req.Method] {
+			sequential = append(sequential, i)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			responses[i] = serve(ctx, &parsed[i])
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, i := range sequential {
+			responses[i] = serve(ctx, &parsed[i])
+		}
+	}()
+
+	wg.Wait()
+
+	out := make([]*jsonrpc.Response, 0, len(responses))
+	for i, res := range responses {
+		if res == nil {
+			continue
+		}
+		// Notifications only get a response if they were malformed
+		if parsed[i].Method != "" && parsed[i].ID == nil {
+			continue
+		}
+		out = append(out, res)
+	}
+
+	return out
+}
+
+// fallbackRequest parses a single request that has no local handler and
+// should go to the fallback instead of the go-kit server
+func (h *batchHandler) fallbackRequest(body []byte) (*jsonrpc.Request, bool) {
+	if h.fallback == nil {
+		return nil, false
+	}
+
+	var req jsonrpc.Request
+	if err := json.Unmarshal(body, &req); err != nil || req.Method == "" {
+		return nil, false
+	}
+
+	if _, ok := h.ecm[req.Method]; ok {
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func (h *batchHandler) serveRequest(ctx context.Context, req *jsonrpc.Request) *jsonrpc.Response {
+	res, _ := h.callRequest(ctx, req)
+	return res
+}
+
+// callRequest is serveRequest that also returns the error behind an error
+// response
+func (h *batchHandler) callRequest(ctx context.Context, req *jsonrpc.Request) (res *jsonrpc.Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("method", req.Method).Error("RPC call panicked: ", r)
+			err = fmt.Errorf("%v", r)
+			res = errorResponse(req.ID, jsonrpc.Error{Code: jsonrpc.InternalError, Message: fmt.Sprint(r)})
+		}
+	}()
+
+	ecm, ok := h.ecm[req.Method]
+	if !ok && h.fallback != nil {
+		result, err := h.fallback(ctx, req.Method, req.Params)
+		if err != nil {
+			return errorResponse(req.ID, rpcError(err)), err
+		}
+		return &jsonrpc.Response{JSONRPC: jsonrpc.Version, Result: result, ID: req.ID}, nil
+	}
+	if !ok {
+		return errorResponse(req.ID, jsonrpc.Error{Code: jsonrpc.MethodNotFoundError, Message: fmt.Sprintf("Method %s was not found.", req.Method)}), nil
+	}
+
+	params, err := ecm.Decode(ctx, req.Params)
+	if err != nil {
+		return errorResponse(req.ID, rpcError(err)), err
+	}
+
+	response, err := ecm.Endpoint(ctx, params)
+	if err != nil {
+		return errorResponse(req.ID, rpcError(err)), err
+	}
+
+	result, err := ecm.Encode(ctx, response)
+	if err != nil {
+		return errorResponse(req.ID, rpcError(err)), err
+	}
+
+	return &jsonrpc.Response{JSONRPC: jsonrpc.Version, Result: result, ID: req.ID}, nil
+}
+
+func errorResponse(id *jsonrpc.RequestID, e jsonrpc.Error) *jsonrpc.Response {
+	return &jsonrpc.Response{JSONRPC: jsonrpc.Version, Error: &e, ID: id}
+}
+
+func writeRPCResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", jsonrpc.ContentType)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRPCResponse struct {
+	ID     interface{}     `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func postRPC(t *testing.T, url string, body string) []byte {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("cannot post %s", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("cannot read response %s", err)
+	}
+
+	return b
+}
+
+func TestBatchRequest(t *testing.T) {
+	svc, _ := NewTestService()
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	body := `[
+		{"jsonrpc":"2.0","id":1,"method":"personal_newAccount","params":[""]},
+		{"jsonrpc":"2.0","id":"two","method":"no_suchMethod","params":[]},
+		{"jsonrpc":"2.0","method":"personal_newAccount","params":[""]},
+		42,
+		{"jsonrpc":"2.0","id":3,"method":"executor_getTransaction","params":["0x01"]},
+		{"jsonrpc":"2.0","id":4,"method":"personal_newAccount","params":[""]}
+	]`
+
+	var responses []testRPCResponse
+	if err := json.Unmarshal(postRPC(t, srv.URL, body), &responses); err != nil {
+		t.Fatalf("cannot decode batch response %s", err)
+	}
+
+	if len(responses) != 5 {
+		t.Fatalf("expected 5 responses, got %d", len(responses))
+	}
+
+	if responses[0].ID != float64(1) || responses[0].Error != nil || len(responses[0].Result) != 44 {
+		t.Fatalf("unexpected response %+v", responses[0])
+	}
+
+	if responses[1].ID != "two" || responses[1].Error == nil || responses[1].Error.Code != -32601 {
+		t.Fatalf("unexpected response %+v", responses[1])
+	}
+
+	if responses[2].ID != nil || responses[2].Error == nil || responses[2].Error.Code != -32600 {
+		t.Fatalf("unexpected response %+v", responses[2])
+	}
+
+	if responses[3].ID != float64(3) || responses[3].Error == nil || responses[3].Error.Message != ErrJournalDisabled.Error() {
+		t.Fatalf("unexpected response %+v", responses[3])
+	}
+
+	if responses[4].ID != float64(4) || responses[4].Error != nil {
+		t.Fatalf("unexpected response %+v", responses[4])
+	}
+
+	accs, _ := svc.signer.Accounts()
+	if len(accs) != 3 {
+		t.Fatalf("expected 3 accounts, got %d", len(accs))
+	}
+}
+
+func TestBatchRequestEdgeCases(t *testing.T) {
+	svc, _ := NewTestService()
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	var res testRPCResponse
+	if err := json.Unmarshal(postRPC(t, srv.URL, `[]`), &res); err != nil || res.Error == nil || res.Error.Code != -32600 {
+		t.Fatalf("unexpected response to empty batch %+v %v", res, err)
+	}
+
+	if b := postRPC(t, srv.URL, `[{"jsonrpc":"2.0","method":"personal_newAccount","params":[""]}]`); len(b) != 0 {
+		t.Fatalf("unexpected response to notifications %s", b)
+	}
+
+	// Single requests still go through the go-kit server
+	res = testRPCResponse{}
+	if err := json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":7,"method":"personal_newAccount","params":[""]}`), &res); err != nil || res.Error != nil || res.ID != float64(7) {
+		t.Fatalf("unexpected single response %+v %v", res, err)
+	}
+}
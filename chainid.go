@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/eximchain/eth-client/quorum"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+// resolveChainID parses the -chain-id setting. Empty or zero keeps homestead
+// signatures, as Quorum networks need, and "auto" uses the node's network ID.
+func resolveChainID(ctx context.Context, setting string, client quorum.Client) (*big.Int, error) {
+	switch setting {
+	case "", "0":
+		return nil, nil
+	case "auto":
+		return client.NetworkID(ctx)
+	}
+
+	chainID, ok := new(big.Int).SetString(setting, 0)
+	if !ok || chainID.Sign() < 0 {
+		return nil, fmt.Errorf("invalid chain ID %q", setting)
+	}
+
+	return chainID, nil
+}
+
+// checkChainID rejects raw transactions that are replay protected for another
+// chain. Unprotected transactions and transactions that do not decode are
+// left for the node to judge, as are those with the V of a Quorum private
+// transaction, which is also that of chain ID 1.
+func (svc transactionExecutorService) checkChainID(params interface{}) error {
+	if svc.chainID == nil {
+		return nil
+	}
+
+	tx := decodeRawTransaction(params)
+	if tx == nil || !tx.Protected() || isPrivate(tx) {
+		return nil
+	}
+
+	if tx.ChainId().Cmp(svc.chainID) != 0 {
+		return ErrChainIDMismatch.derive(fmt.Sprintf("%s: got %s, expected %s", ErrChainIDMismatch.Message, tx.ChainId(), svc.chainID), nil)
+	}
+
+	return nil
+}
+
+// decodeRawTransaction returns the transaction in eth_sendRawTransaction
+// params, or nil if they do not hold one
+func decodeRawTransaction(params interface{}) *types.Transaction {
+	args, ok := params.([]interface{})
+	if !ok || len(args) < 1 {
+		return nil
+	}
+	encoded, ok := args[0].(string)
+	if !ok {
+		return nil
+	}
+	raw, err := hexutil.Decode(encoded)
+	if err != nil {
+		return nil
+	}
+
+	tx := new(types.Transaction)
+	if err := ethRlp.DecodeBytes(raw, tx); err != nil {
+		return nil
+	}
+
+	return tx
+}
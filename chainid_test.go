@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+func TestResolveChainID(t *testing.T) {
+	q := newFakeQuorum()
+	q.networkID = big.NewInt(1337)
+	ctx := context.Background()
+
+	for setting, expected := range map[string]*big.Int{
+		"":     nil,
+		"0":    nil,
+		"auto": big.NewInt(1337),
+		"5":    big.NewInt(5),
+		"0x10": big.NewInt(16),
+	} {
+		chainID, err := resolveChainID(ctx, setting, q)
+		if err != nil || (chainID == nil) != (expected == nil) || (chainID != nil && chainID.Cmp(expected) != 0) {
+			t.Fatalf("expected %v for %q, got %v %v", expected, setting, chainID, err)
+		}
+	}
+
+	for _, setting := range []string{"mainnet", "-1"} {
+		if _, err := resolveChainID(ctx, setting, q); err == nil {
+			t.Fatalf("expected error for %q", setting)
+		}
+	}
+}
+
+func TestEIP155Signing(t *testing.T) {
+	svc, q := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	if _, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	if q.sent[0].Protected() {
+		t.Fatal("expected a homestead transaction without a chain ID")
+	}
+
+	svc.chainID = big.NewInt(1337)
+	if _, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	if !q.sent[1].Protected() || q.sent[1].ChainId().Int64() != 1337 {
+		t.Fatalf("expected chain ID 1337, got %s", q.sent[1].ChainId())
+	}
+}
+
+func TestCheckChainID(t *testing.T) {
+	svc, _ := NewTestService()
+	from, err := svc.GenerateKey(context.Background())
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	account, _ := svc.account(from)
+
+	rawFor := func(chainID *big.Int) []interface{} {
+		tx := types.NewTransaction(0, account.Address, big.NewInt(1), 21000, big.NewInt(0), nil)
+		tx, err := svc.signer.SignTx(account, tx, chainID)
+		if err != nil {
+			t.Fatalf("cannot sign %s", err)
+		}
+		raw, _ := ethRlp.EncodeToBytes(tx)
+		return []interface{}{hexutil.Encode(raw)}
+	}
+
+	// Without a chain ID nothing is checked
+	if err := svc.checkChainID(rawFor(big.NewInt(5))); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	svc.chainID = big.NewInt(1337)
+	for _, params := range []interface{}{rawFor(big.NewInt(1337)), rawFor(nil), []interface{}{"0xnope"}, nil} {
+		if err := svc.checkChainID(params); err != nil {
+			t.Fatalf("unexpected error for %v: %s", params, err)
+		}
+	}
+
+	err = svc.checkChainID(rawFor(big.NewInt(5)))
+	if e, ok := err.(*RPCError); !ok || !e.Is(ErrChainIDMismatch) || e.Code != -32602 {
+		t.Fatalf("expected %s, got %v", ErrChainIDMismatch, err)
+	}
+}
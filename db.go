@@ -2,14 +2,21 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -18,7 +25,21 @@ import (
 
 type BoltDB struct {
 	*bolt.DB
-	userBucket []byte
+	userBucket  []byte
+	nonceBucket []byte
+	txBucket    []byte
+	// Hashes of the journal records that are still watched for a receipt
+	openTxBucket []byte
+	// API tokens, keyed by their prefix
+	tokenBucket []byte
+	// Transactions each user has sent today, keyed by email
+	quotaBucket []byte
+	// Transaction policies, and the value spent under them today, keyed
+	// by the account or user they apply to
+	policyBucket []byte
+	spendBucket  []byte
+	// Users allowed to use each account, keyed by lowercase address
+	accountBucket []byte
 }
 
 func (db *BoltDB) open(name string) error {
@@ -35,116 +56,1274 @@ func (db *BoltDB) open(name string) error {
 	}
 
 	db.userBucket = []byte("users")
+	db.nonceBucket = []byte("nonces")
+	db.txBucket = []byte("transactions")
+	db.openTxBucket = []byte("openTransactions")
+	db.accountBucket = []byte("accounts")
+	db.tokenBucket = []byte("tokens")
+	db.quotaBucket = []byte("quotas")
+	db.policyBucket = []byte("policies")
+	db.spendBucket = []byte("spending")
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(db.userBucket)
+		users, err := tx.CreateBucketIfNotExists(db.userBucket)
 
 		if err != nil {
 			return errors.New("create user bucket error")
 		}
 
+		tokens, err := tx.CreateBucketIfNotExists(db.tokenBucket)
+
+		if err != nil {
+			return errors.New("create token bucket error")
+		}
+
+		if err := migrateUsers(users, tokens); err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(db.nonceBucket)
+
+		if err != nil {
+			return errors.New("create nonce bucket error")
+		}
+
+		transactions, err := tx.CreateBucketIfNotExists(db.txBucket)
+
+		if err != nil {
+			return errors.New("create transaction bucket error")
+		}
+
+		indexed := tx.Bucket(db.openTxBucket) != nil
+		open, err := tx.CreateBucketIfNotExists(db.openTxBucket)
+
+		if err != nil {
+			return errors.New("create open transaction bucket error")
+		}
+
+		if !indexed {
+			if err := indexTransactions(transactions, open); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.CreateBucketIfNotExists(db.accountBucket)
+
+		if err != nil {
+			return errors.New("create account bucket error")
+		}
+
+		_, err = tx.CreateBucketIfNotExists(db.quotaBucket)
+
+		if err != nil {
+			return errors.New("create quota bucket error")
+		}
+
+		_, err = tx.CreateBucketIfNotExists(db.policyBucket)
+
+		if err != nil {
+			return errors.New("create policy bucket error")
+		}
+
+		_, err = tx.CreateBucketIfNotExists(db.spendBucket)
+
+		if err != nil {
+			return errors.New("create spending bucket error")
+		}
+
 		return nil
 	})
 
 	return err
 }
 
-func (db *BoltDB) close() error {
-	err := db.DB.Close()
+func (db *BoltDB) close() error {
+	err := db.DB.Close()
+	return err
+}
+
+// User is the record stored for each email in the users bucket
+type User struct {
+	Email string `json:"email"`
+	// One of the roles in auth.go; empty for users created before roles,
+	// who keep full access
+	Role string `json:"role,omitempty"`
+	// Method patterns allowed in addition to those of the role
+	Methods []string `json:"methods,omitempty"`
+	// Zero for users created before tokens were hashed
+	Created time.Time `json:"created"`
+	// Requests per second allowed for reads and for other methods, and
+	// transactions per UTC day; zero is unlimited
+	ReadRate     float64 `json:"readRate,omitempty"`
+	SignRate     float64 `json:"signRate,omitempty"`
+	DailyTxQuota uint64  `json:"dailyTxQuota,omitempty"`
+}
+
+// Token is an API token of a user, stored under the first tokenPrefixLength
+// characters of the token, which are not secret, with a salted hash of the
+// whole token
+type Token struct {
+	Email   string    `json:"email"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	// Zero if the token does not expire
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"lastUsed"`
+	Salt     string    `json:"salt"`
+	Hash     string    `json:"hash"`
+}
+
+const tokenPrefixLength = 8
+
+// defaultTokenName is given to the token created with a user
+const defaultTokenName = "default"
+
+// lastUsedInterval limits how often a token's last use is written
+const lastUsedInterval = time.Minute
+
+var errTokenExpired = errors.New("token expired")
+
+func createToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(b), err
+}
+
+func tokenPrefix(token string) string {
+	if len(token) < tokenPrefixLength {
+		return token
+	}
+
+	return token[:tokenPrefixLength]
+}
+
+func hashToken(salt []byte, token string) string {
+	h := sha256.Sum256(append(salt, token...))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// setToken stores a new salted hash of the token
+func (t *Token) setToken(token string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	t.Salt = base64.StdEncoding.EncodeToString(salt)
+	t.Hash = hashToken(salt, token)
+	return nil
+}
+
+func (t Token) checkToken(token string) bool {
+	salt, err := base64.StdEncoding.DecodeString(t.Salt)
+	if err != nil || t.Hash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashToken(salt, token)), []byte(t.Hash)) == 1
+}
+
+func (t Token) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// decodeUser reads a users bucket value, which is a bare email for users
+// created before roles
+func decodeUser(v []byte) (User, error) {
+	var user User
+	if len(v) == 0 || v[0] != '{' {
+		user.Email = string(v)
+		return user, nil
+	}
+
+	err := json.Unmarshal(v, &user)
+	return user, err
+}
+
+func putJSON(b *bolt.Bucket, k string, record interface{}) error {
+	v, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(k), v)
+}
+
+// putNewToken stores a new token under an unused prefix, returning the token
+func putNewToken(tokens *bolt.Bucket, t Token) (string, error) {
+	for {
+		token, err := createToken()
+		if err != nil {
+			return "", err
+		}
+
+		prefix := tokenPrefix(token)
+		if tokens.Get([]byte(prefix)) != nil {
+			continue
+		}
+
+		if err := t.setToken(token); err != nil {
+			return "", err
+		}
+
+		return token, putJSON(tokens, prefix, t)
+	}
+}
+
+// userTokens returns the prefixes and tokens of a user
+func userTokens(tokens *bolt.Bucket, email string) (map[string]Token, error) {
+	found := map[string]Token{}
+
+	c := tokens.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var t Token
+		if err := json.Unmarshal(v, &t); err != nil {
+			return nil, err
+		}
+
+		if t.Email == email {
+			found[string(k)] = t
+		}
+	}
+
+	return found, nil
+}
+
+// migrateUsers moves the tokens of users stored under a token, as they were
+// before users could have several, to the tokens bucket. Tokens stored in the
+// clear, from before tokens were hashed, are hashed. Users stored before
+// roles existed are made signers.
+func migrateUsers(users *bolt.Bucket, tokens *bolt.Bucket) error {
+	type legacyUser struct {
+		User
+		Salt string `json:"salt"`
+		Hash string `json:"hash"`
+	}
+	legacy := map[string]legacyUser{}
+
+	c := users.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var user legacyUser
+		if len(v) > 0 && v[0] == '{' {
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+		} else {
+			user.Email = string(v)
+		}
+
+		// Users are now stored under their email
+		if string(k) != user.Email {
+			legacy[string(k)] = user
+		}
+	}
+
+	for k, user := range legacy {
+		t := Token{Email: user.Email, Name: defaultTokenName, Created: user.Created, Salt: user.Salt, Hash: user.Hash}
+		prefix := k
+		if user.Hash == "" {
+			prefix = tokenPrefix(k)
+			if err := t.setToken(k); err != nil {
+				return err
+			}
+		}
+
+		if tokens.Get([]byte(prefix)) != nil {
+			return fmt.Errorf("cannot migrate token of %s: prefix %s is in use", user.Email, prefix)
+		}
+
+		existing, err := userTokens(tokens, user.Email)
+		if err != nil {
+			return err
+		}
+		for _, other := range existing {
+			if other.Name == t.Name {
+				t.Name = defaultTokenName + "-" + prefix
+			}
+		}
+
+		if err := users.Delete([]byte(k)); err != nil {
+			return err
+		}
+		if err := putJSON(tokens, prefix, t); err != nil {
+			return err
+		}
+		if users.Get([]byte(user.Email)) == nil {
+			if err := putJSON(users, user.Email, user.User); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(legacy) > 0 {
+		log.Println("Migrated the tokens of", len(legacy), "users")
+	}
+
+	roleless := []User{}
+	c = users.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var user User
+		if len(v) == 0 || v[0] != '{' {
+			continue
+		}
+		if err := json.Unmarshal(v, &user); err != nil {
+			return err
+		}
+		if user.Role == "" {
+			roleless = append(roleless, user)
+		}
+	}
+
+	for _, user := range roleless {
+		user.Role = RoleSigner
+		if err := putJSON(users, user.Email, user); err != nil {
+			return err
+		}
+		log.Println("Warning: user", user.Email, "had no role and is now a signer; give admins --role admin")
+	}
+
+	return nil
+}
+
+// createUser adds a user with a role and a token named default, which is
+// returned but only its hash is stored
+func (db *BoltDB) createUser(email string, role string, methods []string) (string, error) {
+	if len(email) == 0 {
+		return "", errors.New("user email is empty")
+	}
+
+	var token string
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(db.userBucket)
+		if users.Get([]byte(email)) != nil {
+			return errors.New("user already exists")
+		}
+
+		now := time.Now().UTC()
+		if err := putJSON(users, email, User{Email: email, Role: role, Methods: methods, Created: now}); err != nil {
+			return err
+		}
+
+		var err error
+		token, err = putNewToken(tx.Bucket(db.tokenBucket), Token{Email: email, Name: defaultTokenName, Created: now})
+		return err
+	})
+
+	return token, err
+}
+
+// getUser returns the user with a token, with an empty email if there is
+// none, and records when the token was last used. Expired tokens return
+// errTokenExpired.
+func (db *BoltDB) getUser(token string) (User, error) {
+	var user User
+	var found Token
+	prefix := []byte(tokenPrefix(token))
+	now := time.Now().UTC()
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.tokenBucket).Get(prefix)
+		if v == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(v, &found); err != nil {
+			return err
+		}
+		if !found.checkToken(token) {
+			return nil
+		}
+		if found.expired(now) {
+			return errTokenExpired
+		}
+
+		var err error
+		user, err = decodeUser(tx.Bucket(db.userBucket).Get([]byte(found.Email)))
+		return err
+	})
+
+	if err != nil || user.Email == "" || now.Sub(found.LastUsed) < lastUsedInterval {
+		return user, err
+	}
+
+	// Recording the last use is not worth failing the request for
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		found.LastUsed = now
+		return putJSON(tx.Bucket(db.tokenBucket), string(prefix), found)
+	})
+	if err != nil {
+		log.Println("Error: recording token use", err)
+	}
+
+	return user, nil
+}
+
+// getUserByEmail returns the user with an email, with an empty email if there
+// is none
+func (db *BoltDB) getUserByEmail(email string) (User, error) {
+	var user User
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.userBucket).Get([]byte(email))
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		user, err = decodeUser(v)
+		return err
+	})
+
+	return user, err
+}
+
+// updateUser changes the record of a user
+func (db *BoltDB) updateUser(email string, fn func(*User)) error {
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.userBucket)
+
+		v := b.Get([]byte(email))
+		if v == nil {
+			return errors.New("user not found")
+		}
+
+		user, err := decodeUser(v)
+		if err != nil {
+			return err
+		}
+
+		fn(&user)
+
+		return putJSON(b, email, user)
+	})
+
+	return err
+}
+
+// setUserRole sets the role and extra methods of a user
+func (db *BoltDB) setUserRole(email string, role string, methods []string) error {
+	return db.updateUser(email, func(user *User) {
+		user.Role = role
+		user.Methods = methods
+	})
+}
+
+// quotaUsage is what a user has sent on a UTC day, as kept in quotaBucket
+type quotaUsage struct {
+	Day   string `json:"day"`
+	Count uint64 `json:"count"`
+}
+
+// getQuotaUsage reads a user's usage on the day of now. Usage recorded on
+// another day is reset.
+func getQuotaUsage(b *bolt.Bucket, email string, now time.Time) (quotaUsage, error) {
+	used := quotaUsage{Day: utcDay(now)}
+	if v := b.Get([]byte(email)); v != nil {
+		if err := json.Unmarshal(v, &used); err != nil {
+			return quotaUsage{}, err
+		}
+	}
+	if used.Day != utcDay(now) {
+		used = quotaUsage{Day: utcDay(now)}
+	}
+
+	return used, nil
+}
+
+func (used quotaUsage) put(b *bolt.Bucket, email string) error {
+	return putJSON(b, email, used)
+}
+
+// useQuota counts a transaction by a user on the day of now, reporting
+// whether it is within the quota
+func (db *BoltDB) useQuota(email string, quota uint64, now time.Time) (bool, error) {
+	allowed := false
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.quotaBucket)
+
+		used, err := getQuotaUsage(b, email, now)
+		if err != nil {
+			return err
+		}
+		if used.Count >= quota {
+			return nil
+		}
+
+		allowed = true
+		used.Count++
+		return used.put(b, email)
+	})
+
+	return allowed, err
+}
+
+// refundQuota gives back a transaction counted by useQuota on the day of now
+func (db *BoltDB) refundQuota(email string, now time.Time) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.quotaBucket)
+
+		used, err := getQuotaUsage(b, email, now)
+		if err != nil {
+			return err
+		}
+
+		// A new day has already reset the count
+		if used.Count == 0 {
+			return nil
+		}
+
+		used.Count--
+		return used.put(b, email)
+	})
+}
+
+// addToken gives a user another token with a name of their own, expiring
+// after expiry unless it is zero
+func (db *BoltDB) addToken(email string, name string, expiry time.Duration) (string, error) {
+	var token string
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(db.userBucket).Get([]byte(email)) == nil {
+			return errors.New("user not found")
+		}
+
+		tokens := tx.Bucket(db.tokenBucket)
+		existing, err := userTokens(tokens, email)
+		if err != nil {
+			return err
+		}
+		for _, t := range existing {
+			if t.Name == name {
+				return fmt.Errorf("token %s already exists", name)
+			}
+		}
+
+		t := Token{Email: email, Name: name, Created: time.Now().UTC()}
+		if expiry > 0 {
+			t.Expires = t.Created.Add(expiry)
+		}
+
+		token, err = putNewToken(tokens, t)
+		return err
+	})
+
+	return token, err
+}
+
+// revokeToken deletes a user's token by name, reporting whether it existed
+func (db *BoltDB) revokeToken(email string, name string) (bool, error) {
+	revoked := false
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(db.tokenBucket)
+		existing, err := userTokens(tokens, email)
+		if err != nil {
+			return err
+		}
+
+		for prefix, t := range existing {
+			if t.Name == name {
+				revoked = true
+				return tokens.Delete([]byte(prefix))
+			}
+		}
+
+		return nil
+	})
+
+	return revoked, err
+}
+
+// rotateToken replaces a user's token with a new one of the same name and
+// expiry, creating it if the user has no token of that name
+func (db *BoltDB) rotateToken(email string, name string) (string, error) {
+	var token string
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(db.userBucket).Get([]byte(email)) == nil {
+			return errors.New("user not found")
+		}
+
+		tokens := tx.Bucket(db.tokenBucket)
+		existing, err := userTokens(tokens, email)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		t := Token{Email: email, Name: name, Created: now}
+		for prefix, old := range existing {
+			if old.Name == name {
+				if !old.Expires.IsZero() {
+					t.Expires = now.Add(old.Expires.Sub(old.Created))
+				}
+				if err := tokens.Delete([]byte(prefix)); err != nil {
+					return err
+				}
+			}
+		}
+
+		token, err = putNewToken(tokens, t)
+		return err
+	})
+
+	return token, err
+}
+
+// listUsers writes each user's tokens, with their prefix, times and the
+// user's permissions and limits
+func (db *BoltDB) listUsers(out io.Writer) error {
+	return db.writeUsers(out, "")
+}
+
+// writeUsers lists the tokens of every user, or only of the user with email
+// if it is set
+func (db *BoltDB) writeUsers(out io.Writer, email string) error {
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(db.tokenBucket)
+		c := tx.Bucket(db.userBucket).Cursor()
+
+		w := tabwriter.NewWriter(out, 0, 0, 4, ' ', 0)
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			user, err := decodeUser(v)
+			if err != nil {
+				return err
+			}
+			if email != "" && user.Email != email {
+				continue
+			}
+
+			role := user.Role
+			if role == "" {
+				role = RoleAdmin
+			}
+
+			userTokens, err := userTokens(tokens, user.Email)
+			if err != nil {
+				return err
+			}
+
+			prefixes := make([]string, 0, len(userTokens))
+			for prefix := range userTokens {
+				prefixes = append(prefixes, prefix)
+			}
+			sort.Strings(prefixes)
+
+			methods := strings.Join(user.Methods, ",")
+			if methods == "" {
+				methods = "-"
+			}
+
+			if len(prefixes) == 0 {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t%s\t%s\t%s\n", user.Email, role, methods, formatLimits(user))
+			}
+			for _, prefix := range prefixes {
+				t := userTokens[prefix]
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", user.Email, t.Name, prefix, formatTime(t.Created), formatTime(t.Expires), formatTime(t.LastUsed), role, methods, formatLimits(user))
+			}
+		}
+
+		w.Flush()
+
+		return nil
+	})
+
+	return err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// deleteUser deletes a user and their tokens, reporting whether they existed
+func (db *BoltDB) deleteUser(email string) (bool, error) {
+	deleted := false
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(db.userBucket)
+		if users.Get([]byte(email)) == nil {
+			return nil
+		}
+		deleted = true
+
+		tokens := tx.Bucket(db.tokenBucket)
+		existing, err := userTokens(tokens, email)
+		if err != nil {
+			return err
+		}
+		for prefix := range existing {
+			if err := tokens.Delete([]byte(prefix)); err != nil {
+				return err
+			}
+		}
+
+		// Drop the user's grants, quota, policy and spending so that a new
+		// user with the same email does not inherit them
+		accounts := tx.Bucket(db.accountBucket)
+		addresses := [][]byte{}
+		err = accounts.ForEach(func(k, _ []byte) error {
+			addresses = append(addresses, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range addresses {
+			if _, err := removeAccountEmail(accounts, k, email); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(db.quotaBucket).Delete([]byte(email)); err != nil {
+			return err
+		}
+		scope := []byte(userPolicyScope(email))
+		if err := tx.Bucket(db.policyBucket).Delete(scope); err != nil {
+			return err
+		}
+		if err := tx.Bucket(db.spendBucket).Delete(scope); err != nil {
+			return err
+		}
+
+		return users.Delete([]byte(email))
+	})
+
+	return deleted, err
+}
+
+// getPolicy returns the policy of an account or user, or nil if it has none
+func (db *BoltDB) getPolicy(scope string) (*Policy, error) {
+	var policy *Policy
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.policyBucket).Get([]byte(scope))
+		if v == nil {
+			return nil
+		}
+
+		policy = &Policy{}
+		return json.Unmarshal(v, policy)
+	})
+
+	return policy, err
+}
+
+func (db *BoltDB) putPolicy(scope string, policy Policy) error {
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(db.policyBucket), scope, policy)
+	})
+
+	return err
+}
+
+// deletePolicy removes a policy, reporting whether it existed
+func (db *BoltDB) deletePolicy(scope string) (bool, error) {
+	deleted := false
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.policyBucket)
+		deleted = b.Get([]byte(scope)) != nil
+		return b.Delete([]byte(scope))
+	})
+
+	return deleted, err
+}
+
+// listPolicies writes the scope and JSON of each policy
+func (db *BoltDB) listPolicies(out io.Writer) error {
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(db.policyBucket).Cursor()
+
+		w := tabwriter.NewWriter(out, 0, 0, 4, ' ', 0)
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			fmt.Fprintf(w, "%s\t%s\n", k, v)
+		}
+
+		w.Flush()
+
+		return nil
+	})
+
 	return err
 }
 
-func (db *BoltDB) createUser(email string) (string, error) {
-	if len(email) == 0 {
-		return "", errors.New("user email is empty")
+// dailySpend is the value spent under a policy scope on a UTC day, as kept
+// in spendBucket
+type dailySpend struct {
+	Day   string   `json:"day"`
+	Total *big.Int `json:"total"`
+}
+
+// getDailySpend reads what has been spent under a scope on the day of now.
+// Spending recorded on another day is reset.
+func getDailySpend(b *bolt.Bucket, scope string, now time.Time) (dailySpend, error) {
+	var spent dailySpend
+	if v := b.Get([]byte(scope)); v != nil {
+		if err := json.Unmarshal(v, &spent); err != nil {
+			return dailySpend{}, err
+		}
+	}
+	if spent.Day != utcDay(now) || spent.Total == nil {
+		spent = dailySpend{Day: utcDay(now), Total: new(big.Int)}
 	}
 
-	var token string
+	return spent, nil
+}
+
+func (spent dailySpend) put(b *bolt.Bucket, scope string) error {
+	return putJSON(b, scope, spent)
+}
+
+// spendValue adds value to what has been spent on the day of now under each
+// scope, unless that would exceed the scope's limit. It returns the first
+// scope that would be exceeded, having spent nothing, or an empty string.
+func (db *BoltDB) spendValue(limits map[string]*big.Int, value *big.Int, now time.Time) (string, error) {
+	exceeded := ""
+
+	scopes := make([]string, 0, len(limits))
+	for scope := range limits {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
 
 	err := db.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(db.userBucket)
-		t, err := createToken()
+		b := tx.Bucket(db.spendBucket)
+
+		spent := make([]dailySpend, len(scopes))
+		for i, scope := range scopes {
+			var err error
+			if spent[i], err = getDailySpend(b, scope, now); err != nil {
+				return err
+			}
+
+			spent[i].Total.Add(spent[i].Total, value)
+			if spent[i].Total.Cmp(limits[scope]) > 0 {
+				exceeded = scope
+				return nil
+			}
+		}
+
+		for i, scope := range scopes {
+			if err := spent[i].put(b, scope); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return exceeded, err
+}
+
+// refundValue takes value back off what has been spent on the day of now
+// under each scope, after a transaction it was spent on could not be sent
+func (db *BoltDB) refundValue(scopes []string, value *big.Int, now time.Time) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.spendBucket)
+
+		for _, scope := range scopes {
+			spent, err := getDailySpend(b, scope, now)
+			if err != nil {
+				return err
+			}
+
+			// A new day has already reset the total
+			if spent.Total.Sign() == 0 {
+				continue
+			}
+
+			spent.Total.Sub(spent.Total, value)
+			if spent.Total.Sign() < 0 {
+				spent.Total.SetInt64(0)
+			}
+			if err := spent.put(b, scope); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// utcDay names the UTC day of now in the quota and spend buckets
+func utcDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// getNonce returns the last nonce used by the executor for an address
+func (db *BoltDB) getNonce(address []byte) (uint64, bool, error) {
+	var nonce uint64
+	found := false
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.nonceBucket)
+		v := b.Get(address)
+		if len(v) == 8 {
+			nonce = binary.BigEndian.Uint64(v)
+			found = true
+		}
+		return nil
+	})
+
+	return nonce, found, err
+}
+
+func (db *BoltDB) putNonce(address []byte, nonce uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, nonce)
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.nonceBucket)
+		return b.Put(address, v)
+	})
+
+	return err
+}
+
+// addAccountUser records that a user may use an account
+func (db *BoltDB) addAccountUser(address string, email string) error {
+	k := []byte(strings.ToLower(address))
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.accountBucket)
+
+		users := []string{}
+		if v := b.Get(k); v != nil {
+			if err := json.Unmarshal(v, &users); err != nil {
+				return err
+			}
+		}
+
+		for _, user := range users {
+			if user == email {
+				return nil
+			}
+		}
+
+		v, err := json.Marshal(append(users, email))
 		if err != nil {
 			return err
 		}
 
-		token = t
-		return b.Put([]byte(token), []byte(email))
+		return b.Put(k, v)
 	})
 
-	return token, err
+	return err
 }
 
-func createToken() (string, error) {
-	b := make([]byte, 32)
-	_, err := rand.Read(b)
+// removeAccountUser stops a user from using an account, reporting whether
+// they could before
+func (db *BoltDB) removeAccountUser(address string, email string) (bool, error) {
+	k := []byte(strings.ToLower(address))
+	removed := false
+
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		removed, err = removeAccountEmail(tx.Bucket(db.accountBucket), k, email)
+		return err
+	})
+
+	return removed, err
+}
+
+// removeAccountEmail removes a user from an account's entry in the accounts
+// bucket, reporting whether they were on it
+func removeAccountEmail(b *bolt.Bucket, k []byte, email string) (bool, error) {
+	v := b.Get(k)
+	if v == nil {
+		return false, nil
+	}
+
+	var users []string
+	if err := json.Unmarshal(v, &users); err != nil {
+		return false, err
+	}
+
+	removed := false
+	remaining := []string{}
+	for _, user := range users {
+		if user == email {
+			removed = true
+		} else {
+			remaining = append(remaining, user)
+		}
+	}
+
+	if !removed {
+		return false, nil
+	}
+
+	if len(remaining) == 0 {
+		return true, b.Delete(k)
+	}
+
+	v, err := json.Marshal(remaining)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	return base64.URLEncoding.EncodeToString(b), err
+	return true, b.Put(k, v)
 }
 
-func (db *BoltDB) getUser(token string) (string, error) {
-	email := ""
-	k := []byte(token)
+// accountAllowed reports whether a user may use an account
+func (db *BoltDB) accountAllowed(address string, email string) (bool, error) {
+	k := []byte(strings.ToLower(address))
+	allowed := false
 
 	err := db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(db.userBucket)
+		b := tx.Bucket(db.accountBucket)
+
 		v := b.Get(k)
-		email = string(v)
+		if v == nil {
+			return nil
+		}
+
+		var users []string
+		if err := json.Unmarshal(v, &users); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if user == email {
+				allowed = true
+				break
+			}
+		}
+
 		return nil
 	})
 
-	return email, err
+	return allowed, err
 }
 
-func (db *BoltDB) getTokenByEmail(email string) (string, error) {
-	token := ""
+// userAccounts returns the lowercase addresses a user may use
+func (db *BoltDB) userAccounts(email string) ([]string, error) {
+	addresses := []string{}
 
 	err := db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(db.userBucket)
+		b := tx.Bucket(db.accountBucket)
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if string(v) == email {
-				token = string(k)
-				return nil
+			var users []string
+			if err := json.Unmarshal(v, &users); err != nil {
+				return err
+			}
+
+			for _, user := range users {
+				if user == email {
+					addresses = append(addresses, string(k))
+					break
+				}
 			}
 		}
 
 		return nil
 	})
 
-	return token, err
+	return addresses, err
 }
 
-func (db *BoltDB) listUsers(out io.Writer) error {
-	err := db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(db.userBucket)
-		c := b.Cursor()
+// putTransaction stores a journal record keyed by its transaction hash
+func (db *BoltDB) putTransaction(record *TransactionRecord) error {
+	v, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
 
-		w := tabwriter.NewWriter(out, 0, 0, 4, ' ', 0)
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		k := []byte(strings.ToLower(record.Hash))
+		b := tx.Bucket(db.txBucket)
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fmt.Fprintf(w, "%s\t%s\n", v, k)
+		return indexTransaction(tx.Bucket(db.openTxBucket), k, record)
+	})
+
+	return err
+}
+
+// isOpen reports whether a journal record is still watched for a receipt.
+// Replaced transactions are watched too in case the original is mined first.
+func (record *TransactionRecord) isOpen() bool {
+	switch record.Status {
+	case TxSigned, TxPending, TxMined, TxReplaced:
+		return true
+	}
+	return false
+}
+
+// indexTransaction adds or removes a record's hash in the open bucket
+func indexTransaction(open *bolt.Bucket, k []byte, record *TransactionRecord) error {
+	if record.isOpen() {
+		return open.Put(k, []byte{})
+	}
+
+	return open.Delete(k)
+}
+
+// indexTransactions builds the open bucket for journals written before it existed
+func indexTransactions(transactions, open *bolt.Bucket) error {
+	return transactions.ForEach(func(k, v []byte) error {
+		var record TransactionRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
 		}
 
-		w.Flush()
+		return indexTransaction(open, k, &record)
+	})
+}
 
-		return nil
+// untrackTransaction stops watching a record without changing its status
+func (db *BoltDB) untrackTransaction(hash string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.openTxBucket).Delete([]byte(strings.ToLower(hash)))
+	})
+}
+
+// openTransactions returns the watched journal records matching the filter,
+// oldest first, without decoding the rest of the journal
+func (db *BoltDB) openTransactions(filter TransactionFilter) ([]TransactionRecord, error) {
+	records := []TransactionRecord{}
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.txBucket)
+
+		return tx.Bucket(db.openTxBucket).ForEach(func(k, _ []byte) error {
+			v := b.Get(k)
+			if v == nil {
+				return nil
+			}
+
+			var record TransactionRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+
+			if filter.matches(&record) {
+				records = append(records, record)
+			}
+			return nil
+		})
 	})
 
-	return err
+	sort.Slice(records, func(i, j int) bool { return records[i].Created.Before(records[j].Created) })
+
+	return records, err
+}
+
+// getTransaction returns the journal record for a hash, or nil if there is none
+func (db *BoltDB) getTransaction(hash string) (*TransactionRecord, error) {
+	var record *TransactionRecord
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.txBucket)
+		v := b.Get([]byte(strings.ToLower(hash)))
+		if v == nil {
+			return nil
+		}
+
+		record = &TransactionRecord{}
+		return json.Unmarshal(v, record)
+	})
+
+	return record, err
 }
 
-func (db *BoltDB) deleteUserByToken(token string) error {
-	k := []byte(token)
+// updateTransaction applies fn to the stored record for a hash in a single
+// transaction, so concurrent updates to other fields are not lost
+func (db *BoltDB) updateTransaction(hash string, fn func(*TransactionRecord) error) error {
+	k := []byte(strings.ToLower(hash))
 
 	err := db.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(db.userBucket)
-		return b.Delete(k)
+		b := tx.Bucket(db.txBucket)
+		v := b.Get(k)
+		if v == nil {
+			return ErrTransactionMissing
+		}
+
+		var record TransactionRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+
+		if err := fn(&record); err != nil {
+			return err
+		}
+
+		v, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+
+		return indexTransaction(tx.Bucket(db.openTxBucket), k, &record)
 	})
 
 	return err
 }
+
+// openNonces returns the nonces of the account's pending and signed journal
+// records, which the node may not know about yet
+func (db *BoltDB) openNonces(address string) (map[uint64]bool, error) {
+	records, err := db.openTransactions(TransactionFilter{Account: address})
+	if err != nil {
+		return nil, err
+	}
+
+	nonces := make(map[uint64]bool)
+	for _, record := range records {
+		if strings.EqualFold(record.From, address) && (record.Status == TxPending || record.Status == TxSigned) {
+			nonces[uint64(record.Nonce)] = true
+		}
+	}
+
+	return nonces, nil
+}
+
+// listTransactions returns the journal records matching the filter, oldest first
+func (db *BoltDB) listTransactions(filter TransactionFilter) ([]TransactionRecord, error) {
+	records := []TransactionRecord{}
+
+	err := db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.txBucket)
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var record TransactionRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+
+			if filter.matches(&record) {
+				records = append(records, record)
+			}
+		}
+
+		return nil
+	})
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Created.Before(records[j].Created) })
+
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+
+	return records, err
+}
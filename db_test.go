@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
 )
 
 func NewTestDB() *BoltDB {
@@ -30,8 +36,9 @@ func TestCreateToken(t *testing.T) {
 func TestUser(t *testing.T) {
 	db := NewTestDB()
 	defer db.close()
+	db.deleteUser("test@example.com")
 
-	token, err := db.createUser("test@example.com")
+	token, err := db.createUser("test@example.com", RoleSigner, nil)
 	if err != nil {
 		t.Fatalf("cannot create user %s", err)
 	}
@@ -40,32 +47,230 @@ func TestUser(t *testing.T) {
 		t.Fatalf("cannot create user %s", token)
 	}
 
-	email, err := db.getUser(token)
+	user, err := db.getUser(token)
 	if err != nil {
 		t.Fatalf("cannot get user %s", err)
 	}
 
-	if email != "test@example.com" {
-		t.Fatalf("cannot get user %s", email)
+	if user.Email != "test@example.com" {
+		t.Fatalf("cannot get user %s", user.Email)
 	}
 
-	token1, err := db.getTokenByEmail(email)
+	user1, err := db.getUser(tokenPrefix(token) + "wrong")
 	if err != nil {
-		t.Fatalf("cannot get token by email %s", err)
+		t.Fatalf("cannot get user %s", err)
 	}
 
-	if token1 != token {
-		t.Fatalf("cannot get token by email %s %s", token1, token)
+	if len(user1.Email) > 0 {
+		t.Fatalf("found user %s with the wrong token", user1.Email)
 	}
 
-	db.deleteUserByToken(token1)
+	db.deleteUser(user.Email)
 
-	email1, err := db.getUser(token1)
+	user1, err = db.getUser(token)
 	if err != nil {
 		t.Fatalf("cannot get user %s", err)
 	}
 
-	if len(email1) > 0 {
-		t.Fatalf("cannot delete user %s", email1)
+	if len(user1.Email) > 0 {
+		t.Fatalf("cannot delete user %s", user1.Email)
+	}
+}
+
+func TestDeleteUserCleanup(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	email := "cleanup@example.com"
+	address := "0x00000000000000000000000000000000000000c1"
+	db.deleteUser(email)
+	if _, err := db.createUser(email, RoleSigner, nil); err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+
+	scope := userPolicyScope(email)
+	db.addAccountUser(address, email)
+	db.addAccountUser(address, "other@example.com")
+	defer db.removeAccountUser(address, "other@example.com")
+	db.useQuota(email, 10, time.Now())
+	db.putPolicy(scope, Policy{MaxDailyValue: big.NewInt(100)})
+	db.spendValue(map[string]*big.Int{scope: big.NewInt(100)}, big.NewInt(1), time.Now())
+
+	if deleted, err := db.deleteUser(email); err != nil || !deleted {
+		t.Fatalf("cannot delete user %v %s", deleted, err)
+	}
+
+	if allowed, err := db.accountAllowed(address, email); err != nil || allowed {
+		t.Fatalf("expected the grant to be removed, got %v %v", allowed, err)
+	}
+	if allowed, err := db.accountAllowed(address, "other@example.com"); err != nil || !allowed {
+		t.Fatalf("expected other grants to stay, got %v %v", allowed, err)
+	}
+	if policy, err := db.getPolicy(scope); err != nil || policy != nil {
+		t.Fatalf("expected the policy to be removed, got %+v %v", policy, err)
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(db.quotaBucket).Get([]byte(email)) != nil {
+			t.Error("expected the quota to be removed")
+		}
+		if tx.Bucket(db.spendBucket).Get([]byte(scope)) != nil {
+			t.Error("expected the spending to be removed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot read database %s", err)
+	}
+}
+
+func TestDailySpend(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	scope := accountPolicyScope("0x00000000000000000000000000000000000000d1")
+	clear := func() {
+		db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(db.spendBucket).Delete([]byte(scope))
+		})
+	}
+	clear()
+	defer clear()
+
+	limits := map[string]*big.Int{scope: big.NewInt(10)}
+	today := time.Now().UTC()
+	tomorrow := today.Add(24 * time.Hour)
+
+	if exceeded, err := db.spendValue(limits, big.NewInt(6), today); err != nil || exceeded != "" {
+		t.Fatalf("expected the value within the limit, got %q %v", exceeded, err)
+	}
+
+	// A refund from the day before leaves the new day alone
+	if exceeded, err := db.spendValue(limits, big.NewInt(6), tomorrow); err != nil || exceeded != "" {
+		t.Fatalf("expected a new day to start again, got %q %v", exceeded, err)
+	}
+	db.refundValue([]string{scope}, big.NewInt(6), today)
+	if exceeded, _ := db.spendValue(limits, big.NewInt(5), tomorrow); exceeded != scope {
+		t.Fatalf("expected the limit to be exceeded, got %q", exceeded)
+	}
+
+	db.refundValue([]string{scope}, big.NewInt(6), tomorrow)
+	if exceeded, _ := db.spendValue(limits, big.NewInt(10), tomorrow); exceeded != "" {
+		t.Fatalf("expected the refund to free the limit, got %q", exceeded)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	db.deleteUser("tokens@example.com")
+	defer db.deleteUser("tokens@example.com")
+
+	first, err := db.createUser("tokens@example.com", RoleSigner, nil)
+	if err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+	second, err := db.addToken("tokens@example.com", "ci", 0)
+	if err != nil {
+		t.Fatalf("cannot add token %s", err)
+	}
+	if _, err := db.addToken("tokens@example.com", "ci", 0); err == nil {
+		t.Fatal("expected a duplicate token name to fail")
+	}
+
+	for _, token := range []string{first, second} {
+		if user, err := db.getUser(token); err != nil || user.Email != "tokens@example.com" {
+			t.Fatalf("cannot get user %+v %v", user, err)
+		}
+	}
+
+	var out bytes.Buffer
+	db.writeUsers(&out, "tokens@example.com")
+	if strings.Count(out.String(), "\n") != 2 || strings.Contains(out.String(), first) || !strings.Contains(out.String(), tokenPrefix(second)) {
+		t.Fatalf("unexpected token list %q", out.String())
+	}
+
+	// Rotating one token leaves the other working
+	rotated, err := db.rotateToken("tokens@example.com", defaultTokenName)
+	if err != nil {
+		t.Fatalf("cannot rotate token %s", err)
+	}
+	if user, _ := db.getUser(first); user.Email != "" {
+		t.Fatal("expected the rotated token to stop working")
+	}
+	for _, token := range []string{rotated, second} {
+		if user, err := db.getUser(token); err != nil || user.Email != "tokens@example.com" {
+			t.Fatalf("cannot get user %+v %v", user, err)
+		}
+	}
+
+	if revoked, err := db.revokeToken("tokens@example.com", "ci"); err != nil || !revoked {
+		t.Fatalf("cannot revoke token %v", err)
+	}
+	if user, _ := db.getUser(second); user.Email != "" {
+		t.Fatal("expected the revoked token to stop working")
+	}
+
+	expiring, err := db.addToken("tokens@example.com", "short", time.Millisecond)
+	if err != nil {
+		t.Fatalf("cannot add token %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := db.getUser(expiring); err != errTokenExpired {
+		t.Fatalf("expected %s, got %v", errTokenExpired, err)
+	}
+}
+
+func TestTokenMigration(t *testing.T) {
+	db := NewTestDB()
+
+	token, err := createToken()
+	if err != nil {
+		t.Fatalf("cannot create token: %s", err)
+	}
+
+	hashed, err := createToken()
+	if err != nil {
+		t.Fatalf("cannot create token: %s", err)
+	}
+	var hash Token
+	hash.setToken(hashed)
+
+	// Users were stored under their token before it was hashed, then under
+	// its prefix with its hash before they could have several
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.userBucket)
+		if err := b.Put([]byte(token), []byte("legacy@example.com")); err != nil {
+			return err
+		}
+		v := `{"email":"hashed@example.com","role":"signer","salt":"` + hash.Salt + `","hash":"` + hash.Hash + `"}`
+		return b.Put([]byte(tokenPrefix(hashed)), []byte(v))
+	})
+	if err != nil {
+		t.Fatalf("cannot store legacy user %s", err)
+	}
+	db.close()
+
+	db = NewTestDB()
+	defer db.close()
+	defer db.deleteUser("legacy@example.com")
+	defer db.deleteUser("hashed@example.com")
+
+	user, err := db.getUser(token)
+	if err != nil || user.Email != "legacy@example.com" || user.Role != RoleSigner {
+		t.Fatalf("cannot get migrated user %+v %v", user, err)
+	}
+
+	user, err = db.getUser(hashed)
+	if err != nil || user.Email != "hashed@example.com" || user.Role != RoleSigner {
+		t.Fatalf("cannot get migrated user %+v %v", user, err)
+	}
+
+	var out bytes.Buffer
+	if err := db.listUsers(&out); err != nil {
+		t.Fatalf("cannot list users %s", err)
+	}
+	if strings.Contains(out.String(), token) || !strings.Contains(out.String(), tokenPrefix(token)) {
+		t.Fatalf("expected only the token prefix in %q", out.String())
 	}
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// Server error codes used by Ethereum clients, from EIP-1474. The standard
+// JSON-RPC codes are defined in the jsonrpc package.
+const (
+	ErrCodeServer              = -32000
+	ErrCodeResourceNotFound    = -32001
+	ErrCodeResourceUnavailable = -32002
+	ErrCodeTransactionRejected = -32003
+	ErrCodeMethodNotSupported  = -32004
+	ErrCodeLimitExceeded       = -32005
+)
+
+// RPCError is an executor error with a JSON-RPC code and optional data
+type RPCError struct {
+	Code    int
+	Message string
+	Data    interface{}
+	// The sentinel this error was derived from, for errors.Is
+	parent *RPCError
+}
+
+func newRPCError(code int, message string) *RPCError {
+	return &RPCError{Code: code, Message: message}
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// ErrorCode implements jsonrpc.ErrorCoder
+func (e *RPCError) ErrorCode() int {
+	return e.Code
+}
+
+// ErrorData is sent as the data of the JSON-RPC error
+func (e *RPCError) ErrorData() interface{} {
+	return e.Data
+}
+
+// Is reports whether e is target or was derived from it
+func (e *RPCError) Is(target error) bool {
+	return target == error(e) || (e.parent != nil && target == error(e.parent))
+}
+
+// derive returns a copy of e with a more specific message and data
+func (e *RPCError) derive(message string, data interface{}) *RPCError {
+	parent := e
+	if e.parent != nil {
+		parent = e.parent
+	}
+
+	return &RPCError{Code: e.Code, Message: message, Data: data, parent: parent}
+}
+
+// NodeError is the data of errors caused by a failure reported by the node
+type NodeError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// nodeError wraps an error from the quorum client in e, passing the node's
+// code, message and data through in the error data
+func nodeError(e *RPCError, err error) *RPCError {
+	data := NodeError{Code: ErrCodeServer, Message: err.Error()}
+	if coder, ok := err.(jsonrpc.ErrorCoder); ok {
+		data.Code = coder.ErrorCode()
+	}
+	if rpcErr, ok := err.(jsonrpc.Error); ok {
+		data.Data = rpcErr.Data
+	}
+
+	wrapped := e.derive(e.Message+": "+err.Error(), data)
+	if isTransactionRejected(err) {
+		wrapped.Code = ErrCodeTransactionRejected
+	}
+
+	return wrapped
+}
+
+// invalidParams returns ErrInvalidParams with the reason in its message
+func invalidParams(reason string) *RPCError {
+	return ErrInvalidParams.derive(ErrInvalidParams.Message+": "+reason, nil)
+}
+
+// transactionRejections are txpool errors for transactions the node will not accept
+var transactionRejections = []string{
+	"nonce too low",
+	"insufficient funds",
+	"gas limit",
+	"intrinsic gas too low",
+	"underpriced",
+	"known transaction",
+	"oversized data",
+	"negative value",
+	"invalid sender",
+}
+
+func isTransactionRejected(err error) bool {
+	msg := err.Error()
+	for _, rejection := range transactionRejections {
+		if strings.Contains(msg, rejection) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rpcError converts an error to a JSON-RPC error, using its code and data if
+// it has them
+func rpcError(err error) jsonrpc.Error {
+	if e, ok := err.(jsonrpc.Error); ok {
+		return e
+	}
+
+	e := jsonrpc.Error{Code: jsonrpc.InternalError, Message: err.Error()}
+	if sc, ok := err.(jsonrpc.ErrorCoder); ok {
+		e.Code = sc.ErrorCode()
+	}
+	if d, ok := err.(interface{ ErrorData() interface{} }); ok {
+		e.Data = d.ErrorData()
+	}
+
+	return e
+}
+
+// encodeRPCError is the go-kit server's error encoder. Unlike the default it
+// keeps the error data, and it answers requests over a user's limits with
+// HTTP 429.
+func encodeRPCError(_ context.Context, err error, w http.ResponseWriter) {
+	if overLimit(err) {
+		w.Header().Set("Content-Type", jsonrpc.ContentType)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+
+	writeRPCResponse(w, errorResponse(nil, rpcError(err)))
+}
+
+// overLimit reports whether a request failed for being over one of the
+// user's limits, which HTTP answers with status 429
+func overLimit(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTxQuotaExceeded)
+}
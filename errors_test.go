@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+type testRPCErrorResponse struct {
+	Error *struct {
+		Code    int       `json:"code"`
+		Message string    `json:"message"`
+		Data    NodeError `json:"data"`
+	} `json:"error"`
+}
+
+func TestNodeError(t *testing.T) {
+	err := nodeError(ErrQuorum, jsonrpc.Error{Code: -32010, Message: "insufficient funds for gas * price + value"})
+	if err.Code != ErrCodeTransactionRejected || err.Message != "error using quorum client: insufficient funds for gas * price + value" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if data := err.Data.(NodeError); data.Code != -32010 || data.Message != "insufficient funds for gas * price + value" {
+		t.Fatalf("unexpected data %+v", data)
+	}
+	if !errors.Is(err, ErrQuorum) || errors.Is(err, ErrSigning) {
+		t.Fatal("expected error derived from ErrQuorum only")
+	}
+
+	err = nodeError(ErrQuorum, errors.New("connection refused"))
+	if err.Code != ErrCodeServer || err.Data.(NodeError).Code != ErrCodeServer {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	if e := rpcError(invalidParams("bad")); e.Code != jsonrpc.InvalidParamsError || e.Message != "invalid params: bad" {
+		t.Fatalf("unexpected invalid params %+v", e)
+	}
+}
+
+func TestRPCErrorResponse(t *testing.T) {
+	svc, q := NewTestService()
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	from, err := svc.GenerateKey(context.Background())
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	q.sendErr = jsonrpc.Error{Code: -32010, Message: "insufficient funds"}
+
+	for _, c := range []struct {
+		body string
+		code int
+		data NodeError
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_sendTransaction","params":{}}`, jsonrpc.InvalidParamsError, NodeError{}},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_sign","params":["0x0000000000000000000000000000000000000001","0x01"]}`, ErrCodeResourceNotFound, NodeError{}},
+		{`{"jsonrpc":"2.0","id":1,"method":"executor_getTransaction","params":["0x01"]}`, ErrCodeMethodNotSupported, NodeError{}},
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_sendTransaction","params":[{"from":"` + from + `","to":"0x0000000000000000000000000000000000000002"}]}`, ErrCodeTransactionRejected, NodeError{Code: -32010, Message: "insufficient funds"}},
+	} {
+		var res testRPCErrorResponse
+		if err := json.Unmarshal(postRPC(t, srv.URL, c.body), &res); err != nil {
+			t.Fatalf("cannot decode response %s", err)
+		}
+		if res.Error == nil || res.Error.Code != c.code || res.Error.Data != c.data {
+			t.Fatalf("unexpected error for %s: %+v", c.body, res.Error)
+		}
+	}
+}
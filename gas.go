@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	ethereum "github.com/eximchain/go-ethereum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+)
+
+const defaultGasMultiplier = 1.2
+
+// gasConfig controls how missing gas limits and prices are filled in
+type gasConfig struct {
+	// Applied to the node's estimate to leave a safety margin; values below 1
+	// use the estimate as is
+	multiplier float64
+	// Upper bound for estimated gas limits; zero is unlimited
+	cap uint64
+	// Used for every transaction without a gas price if set, e.g. zero on
+	// Quorum networks; otherwise the node suggests one
+	fixedPrice *big.Int
+}
+
+// gasDefaults records which gas values the executor chose for a transaction
+type gasDefaults struct {
+	// The node's estimate before the multiplier and cap; zero if the caller
+	// gave the gas limit
+	estimate     uint64
+	defaultPrice bool
+}
+
+func (d gasDefaults) apply(record *TransactionRecord) {
+	record.GasEstimate = hexutil.Uint64(d.estimate)
+	record.GasPriceDefault = d.defaultPrice
+}
+
+// fillGas returns the gas limit and price for a transaction, estimating the
+// limit if it is zero and choosing a price if it is nil
+func (svc transactionExecutorService) fillGas(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) (uint64, *big.Int, gasDefaults, error) {
+	defaults := gasDefaults{}
+
+	if gasPrice == nil {
+		if svc.gas.fixedPrice != nil {
+			gasPrice = new(big.Int).Set(svc.gas.fixedPrice)
+		} else {
+			suggested, err := svc.quorumClient.SuggestGasPrice(ctx)
+			if err != nil {
+				log.Println("Error: SuggestGasPrice")
+				log.Println(err)
+				return 0, nil, defaults, nodeError(ErrQuorum, err)
+			}
+			gasPrice = suggested
+		}
+		defaults.defaultPrice = true
+		log.Printf("Using gas price %s for transaction from %s", gasPrice, from)
+	}
+
+	if gasLimit == 0 {
+		msg := ethereum.CallMsg{
+			From:     ethCommon.HexToAddress(from),
+			GasPrice: gasPrice,
+			Value:    amount,
+			Data:     data,
+		}
+		if to != "" {
+			recipient := ethCommon.HexToAddress(to)
+			msg.To = &recipient
+		}
+
+		estimate, err := svc.quorumClient.EstimateGas(ctx, msg)
+		if err != nil {
+			log.Println("Error: EstimateGas")
+			log.Println(err)
+			return 0, nil, defaults, nodeError(ErrQuorum, err)
+		}
+
+		if svc.gas.cap > 0 && estimate > svc.gas.cap {
+			return 0, nil, defaults, ErrGasCapExceeded
+		}
+
+		gasLimit = svc.gas.limit(estimate)
+		defaults.estimate = estimate
+		log.Printf("Using gas limit %d (estimate %d) for transaction from %s", gasLimit, estimate, from)
+	}
+
+	return gasLimit, gasPrice, defaults, nil
+}
+
+// ErrGasCapExceeded is returned when a transaction needs more gas than the configured cap
+var ErrGasCapExceeded = newRPCError(ErrCodeTransactionRejected, "gas estimate exceeds the executor's cap")
+
+// limit applies the safety multiplier and cap to a gas estimate
+func (cfg gasConfig) limit(estimate uint64) uint64 {
+	gasLimit := estimate
+	if cfg.multiplier > 1 {
+		gasLimit = uint64(float64(estimate) * cfg.multiplier)
+	}
+
+	if cfg.cap > 0 && gasLimit > cfg.cap {
+		gasLimit = cfg.cap
+	}
+
+	return gasLimit
+}
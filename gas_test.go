@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+)
+
+func TestGasConfigLimit(t *testing.T) {
+	for _, c := range []struct {
+		cfg      gasConfig
+		estimate uint64
+		expected uint64
+	}{
+		{gasConfig{}, 21000, 21000},
+		{gasConfig{multiplier: 0.5}, 21000, 21000},
+		{gasConfig{multiplier: 1.5}, 21000, 31500},
+		{gasConfig{multiplier: 1.5, cap: 30000}, 21000, 30000},
+	} {
+		if limit := c.cfg.limit(c.estimate); limit != c.expected {
+			t.Fatalf("expected %d for %+v, got %d", c.expected, c.cfg, limit)
+		}
+	}
+}
+
+func TestGasDefaults(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	svc.gas = gasConfig{multiplier: 2, cap: 100000}
+	q.gasEstimate = 30000
+	q.gasPrice = big.NewInt(7)
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 0, nil, "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	if q.sent[0].Gas() != 60000 || q.sent[0].GasPrice().Int64() != 7 {
+		t.Fatalf("unexpected gas %d price %s", q.sent[0].Gas(), q.sent[0].GasPrice())
+	}
+
+	record, err := db.getTransaction(txHash)
+	if err != nil || record.GasEstimate != 30000 || !record.GasPriceDefault {
+		t.Fatalf("expected gas defaults in journal, got %+v %v", record, err)
+	}
+
+	// Explicit values are left alone, including a zero gas price
+	svc.gas.fixedPrice = big.NewInt(3)
+	txHash, err = svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	if q.sent[1].Gas() != 21000 || q.sent[1].GasPrice().Sign() != 0 {
+		t.Fatalf("unexpected gas %d price %s", q.sent[1].Gas(), q.sent[1].GasPrice())
+	}
+	if record, _ := db.getTransaction(txHash); record.GasEstimate != 0 || record.GasPriceDefault {
+		t.Fatalf("unexpected gas defaults in journal %+v", record)
+	}
+
+	if _, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, nil, "", nil); err != nil || q.sent[2].GasPrice().Int64() != 3 {
+		t.Fatalf("expected fixed gas price, got %v", err)
+	}
+
+	q.gasEstimate = 200000
+	if _, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 0, nil, "", nil); err != ErrGasCapExceeded {
+		t.Fatalf("expected %s, got %v", ErrGasCapExceeded, err)
+	}
+	if code := rpcError(ErrGasCapExceeded).Code; code != ErrCodeTransactionRejected {
+		t.Fatalf("expected the gas cap to reject the transaction, got code %d", code)
+	}
+}
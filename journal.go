@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	ethereum "github.com/eximchain/go-ethereum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+// Journal statuses. Transactions move from signed or pending to mined once a
+// receipt is seen, then to confirmed or failed after enough confirmations.
+// Pending transactions may also be replaced or dropped by the rebroadcaster.
+const (
+	TxSigned    = "signed"
+	TxPending   = "pending"
+	TxMined     = "mined"
+	TxConfirmed = "confirmed"
+	TxFailed    = "failed"
+	// Superseded by a transaction with the same nonce and a higher gas price
+	TxReplaced = "replaced"
+	// Rejected by the node on rebroadcast because its nonce was already used
+	TxDropped = "dropped"
+)
+
+// TransactionRecord is the journal entry for a transaction signed by the executor
+type TransactionRecord struct {
+	Hash     string         `json:"hash"`
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Nonce    hexutil.Uint64 `json:"nonce"`
+	Value    *hexutil.Big   `json:"value"`
+	Gas      hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big   `json:"gasPrice"`
+	Data     hexutil.Bytes  `json:"data"`
+	User     string         `json:"user"`
+	Status   string         `json:"status"`
+	// The receipt does not carry a block number, so this is the head at which
+	// the receipt was first seen; it is never lower than the real block.
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Created     time.Time      `json:"created"`
+	Updated     time.Time      `json:"updated"`
+	Raw         hexutil.Bytes  `json:"raw"`
+	// Number of rebroadcasts and replacements for this nonce so far
+	Attempts   int    `json:"attempts"`
+	Replaces   string `json:"replaces,omitempty"`
+	ReplacedBy string `json:"replacedBy,omitempty"`
+	// The node's gas estimate if the executor chose the gas limit
+	GasEstimate hexutil.Uint64 `json:"gasEstimate,omitempty"`
+	// Set if the executor chose the gas price
+	GasPriceDefault bool `json:"gasPriceDefault,omitempty"`
+	// Set for Quorum private transactions, whose data is the payload's hash
+	PrivateFrom string   `json:"privateFrom,omitempty"`
+	PrivateFor  []string `json:"privateFor,omitempty"`
+}
+
+// transaction decodes the signed transaction stored in the record
+func (record *TransactionRecord) transaction() (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	err := ethRlp.DecodeBytes(record.Raw, tx)
+	return tx, err
+}
+
+var errStaleRecord = errors.New("journal record changed")
+
+// TransactionFilter selects journal records; empty fields match everything
+type TransactionFilter struct {
+	Account string `json:"account"`
+	User    string `json:"user"`
+	Status  string `json:"status"`
+	Limit   int    `json:"limit"`
+}
+
+func (f TransactionFilter) matches(record *TransactionRecord) bool {
+	if f.Account != "" && !strings.EqualFold(f.Account, record.From) && !strings.EqualFold(f.Account, record.To) {
+		return false
+	}
+
+	if f.User != "" && f.User != record.User {
+		return false
+	}
+
+	if f.Status != "" && f.Status != record.Status {
+		return false
+	}
+
+	return true
+}
+
+func newTransactionRecord(ctx context.Context, tx *types.Transaction, from ethCommon.Address, status string) (*TransactionRecord, error) {
+	raw, err := ethRlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	to := ""
+	if tx.To() != nil {
+		to = strings.ToLower(tx.To().Hex())
+	}
+
+	now := time.Now().UTC()
+	return &TransactionRecord{
+		Hash:     tx.Hash().Hex(),
+		From:     strings.ToLower(from.Hex()),
+		To:       to,
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Data:     tx.Data(),
+		User:     userFromContext(ctx),
+		Status:   status,
+		Created:  now,
+		Updated:  now,
+		Raw:      raw,
+	}, nil
+}
+
+// recordTransaction adds a signed transaction to the journal. Failures are
+// logged rather than returned since the transaction has already been signed.
+func (svc transactionExecutorService) recordTransaction(ctx context.Context, tx *types.Transaction, from ethCommon.Address, status string, defaults gasDefaults) {
+	if svc.db == nil {
+		return
+	}
+
+	record, err := newTransactionRecord(ctx, tx, from, status)
+	if err == nil {
+		defaults.apply(record)
+		err = svc.db.putTransaction(record)
+	}
+
+	if err != nil {
+		log.Println("Error: recording transaction", tx.Hash().Hex(), err)
+	}
+}
+
+// GetTransaction returns the journal record for a hash. Users other than
+// admins only see their own transactions and those of accounts they may use.
+func (svc transactionExecutorService) GetTransaction(ctx context.Context, hash string) (*TransactionRecord, error) {
+	if svc.db == nil {
+		return nil, ErrJournalDisabled
+	}
+
+	scope, err := svc.transactionScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := svc.db.getTransaction(hash)
+	if err != nil || record == nil || !scope.visible(record) {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// ListTransactions returns the journal records matching the filter that the
+// caller may see, as for GetTransaction
+func (svc transactionExecutorService) ListTransactions(ctx context.Context, filter TransactionFilter) ([]TransactionRecord, error) {
+	if svc.db == nil {
+		return nil, ErrJournalDisabled
+	}
+
+	scope, err := svc.transactionScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	filter.Limit = 0
+	records, err := svc.db.listTransactions(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := []TransactionRecord{}
+	for _, record := range records {
+		if scope.visible(&record) {
+			visible = append(visible, record)
+		}
+	}
+
+	if limit > 0 && len(visible) > limit {
+		visible = visible[len(visible)-limit:]
+	}
+
+	return visible, nil
+}
+
+// journalScope limits the journal records a user may see; an empty user
+// sees everything
+type journalScope struct {
+	user     string
+	accounts []string
+}
+
+func (scope journalScope) visible(record *TransactionRecord) bool {
+	return scope.user == "" || record.User == scope.user || containsAddress(scope.accounts, record.From)
+}
+
+// transactionScope returns the records visible to the authenticated user.
+// Admins and unauthenticated callers see every record.
+func (svc transactionExecutorService) transactionScope(ctx context.Context) (journalScope, error) {
+	user := userFromContext(ctx)
+	if user == "" || isAdmin(ctx) {
+		return journalScope{}, nil
+	}
+
+	accounts, err := svc.db.userAccounts(user)
+	if err != nil {
+		log.Println("Error: userAccounts")
+		log.Println(err)
+		return journalScope{}, ErrDatabase
+	}
+
+	return journalScope{user: user, accounts: accounts}, nil
+}
+
+// WatchTransactions follows new heads and updates the status of journal
+// records until the context is cancelled. Head subscriptions need a
+// websocket connection to the node; otherwise the head is polled. Signed and
+// replaced transactions are no longer watched once unchanged for
+// untrackAfter, unless it is zero.
+func (svc transactionExecutorService) WatchTransactions(ctx context.Context, confirmations uint64, interval time.Duration, untrackAfter time.Duration) {
+	heads := make(chan *types.Header)
+	sub, err := svc.subscriptionClient().SubscribeNewHead(ctx, heads)
+	if err != nil {
+		log.Println("New head subscription unavailable, polling every", interval)
+		sub = nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var subErr <-chan error
+	if sub != nil {
+		defer sub.Unsubscribe()
+		subErr = sub.Err()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-subErr:
+			log.Println("New head subscription closed, polling", err)
+			subErr = nil
+		case head := <-heads:
+			svc.updateTransactions(ctx, head.Number.Uint64(), confirmations, untrackAfter)
+		case <-ticker.C:
+			if subErr != nil {
+				continue
+			}
+			head, err := svc.quorumClient.HeaderByNumber(ctx, nil)
+			if err != nil {
+				log.Println("Error: HeaderByNumber", err)
+				continue
+			}
+			svc.updateTransactions(ctx, head.Number.Uint64(), confirmations, untrackAfter)
+		}
+	}
+}
+
+func (svc transactionExecutorService) updateTransactions(ctx context.Context, head uint64, confirmations uint64, untrackAfter time.Duration) {
+	records, err := svc.db.openTransactions(TransactionFilter{})
+	if err != nil {
+		log.Println("Error: listing transactions", err)
+		return
+	}
+
+	for i := range records {
+		record := &records[i]
+
+		status, block := svc.transactionStatus(ctx, record, head, confirmations)
+		if status == record.Status && block == uint64(record.BlockNumber) {
+			// Signed transactions may never be sent and replaced ones are
+			// rarely mined, so stop polling their receipts eventually
+			stale := untrackAfter > 0 && time.Since(record.Updated) >= untrackAfter
+			if stale && (record.Status == TxSigned || record.Status == TxReplaced) {
+				log.Println("No longer watching transaction", record.Hash, record.Status)
+				if err := svc.db.untrackTransaction(record.Hash); err != nil {
+					log.Println("Error: untracking transaction", record.Hash, err)
+				}
+			}
+			continue
+		}
+
+		err := svc.db.updateTransaction(record.Hash, func(r *TransactionRecord) error {
+			// Leave records that changed since they were listed for the next round
+			if r.Status != record.Status {
+				return errStaleRecord
+			}
+			r.Status = status
+			r.BlockNumber = hexutil.Uint64(block)
+			r.Updated = time.Now().UTC()
+			return nil
+		})
+		if err != nil && err != errStaleRecord {
+			log.Println("Error: updating transaction", record.Hash, err)
+		}
+	}
+}
+
+// transactionStatus returns the next status and block number for a record
+func (svc transactionExecutorService) transactionStatus(ctx context.Context, record *TransactionRecord, head uint64, confirmations uint64) (string, uint64) {
+	receipt, err := svc.quorumClient.TransactionReceipt(ctx, ethCommon.HexToHash(record.Hash))
+	if err == ethereum.NotFound {
+		if record.Status == TxMined {
+			// Dropped by a reorg; wait for it to be mined again
+			return TxPending, 0
+		}
+		return record.Status, uint64(record.BlockNumber)
+	}
+	if err != nil {
+		log.Println("Error: TransactionReceipt", record.Hash, err)
+		return record.Status, uint64(record.BlockNumber)
+	}
+
+	block := uint64(record.BlockNumber)
+	if record.Status != TxMined {
+		block = head
+	}
+
+	if head+1 < block+confirmations {
+		return TxMined, block
+	}
+
+	if receipt.Status == types.ReceiptStatusFailed && len(receipt.PostState) == 0 {
+		return TxFailed, block
+	}
+	return TxConfirmed, block
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/eximchain/go-ethereum/core/types"
+)
+
+// ClearTestJournal empties the transaction buckets of the shared test database
+func ClearTestJournal(t *testing.T, db *BoltDB) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{db.txBucket, db.openTxBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("cannot clear journal %s", err)
+	}
+}
+
+func TestTransactionJournal(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.WithValue(context.Background(), userContextKey, "journal@example.com")
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	to := "0x0000000000000000000000000000000000000001"
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "0x01", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	record, err := svc.GetTransaction(ctx, txHash)
+	if err != nil || record == nil {
+		t.Fatalf("cannot get transaction %s %v", txHash, err)
+	}
+
+	if record.Status != TxPending || record.User != "journal@example.com" || record.From != from || len(record.Raw) == 0 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	records, err := svc.ListTransactions(ctx, TransactionFilter{Account: from, Status: TxPending})
+	if err != nil || len(records) != 1 || records[0].Hash != txHash {
+		t.Fatalf("unexpected records %v %v", records, err)
+	}
+
+	records, err = svc.ListTransactions(ctx, TransactionFilter{User: "nobody@example.com"})
+	if err != nil || len(records) != 0 {
+		t.Fatalf("unexpected records %v %v", records, err)
+	}
+
+	// No receipt yet
+	svc.updateTransactions(ctx, 10, 3, 0)
+	if record, _ = svc.GetTransaction(ctx, txHash); record.Status != TxPending {
+		t.Fatalf("expected pending, got %s", record.Status)
+	}
+
+	q.receipts[q.sent[0].Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful}
+	svc.updateTransactions(ctx, 11, 3, 0)
+	if record, _ = svc.GetTransaction(ctx, txHash); record.Status != TxMined || record.BlockNumber != 11 {
+		t.Fatalf("expected mined at 11, got %s at %d", record.Status, record.BlockNumber)
+	}
+
+	svc.updateTransactions(ctx, 13, 3, 0)
+	if record, _ = svc.GetTransaction(ctx, txHash); record.Status != TxConfirmed {
+		t.Fatalf("expected confirmed, got %s", record.Status)
+	}
+
+	// A failed transaction is only reported once confirmed
+	txHash, err = svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	q.receipts[q.sent[1].Hash()] = &types.Receipt{Status: types.ReceiptStatusFailed}
+	svc.updateTransactions(ctx, 20, 1, 0)
+	if record, _ = svc.GetTransaction(ctx, txHash); record.Status != TxFailed {
+		t.Fatalf("expected failed, got %s", record.Status)
+	}
+}
+
+func TestUntrackTransactions(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+	if _, err := svc.EthSignTransaction(ctx, from, to, big.NewInt(2), 21000, big.NewInt(0), "", nil); err != nil {
+		t.Fatalf("cannot sign transaction %s", err)
+	}
+
+	open, err := db.openTransactions(TransactionFilter{})
+	if err != nil || len(open) != 2 {
+		t.Fatalf("expected 2 open records, got %v %v", open, err)
+	}
+
+	// The signed transaction is dropped from the watch list, the pending one is not
+	svc.updateTransactions(ctx, 10, 1, time.Nanosecond)
+	open, err = db.openTransactions(TransactionFilter{})
+	if err != nil || len(open) != 1 || open[0].Hash != txHash {
+		t.Fatalf("expected only the pending record, got %v %v", open, err)
+	}
+
+	signed, err := db.listTransactions(TransactionFilter{Status: TxSigned})
+	if err != nil || len(signed) != 1 {
+		t.Fatalf("expected the signed record to stay in the journal, got %v %v", signed, err)
+	}
+
+	// Confirmed transactions leave the watch list
+	q.receipts[q.sent[0].Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful}
+	svc.updateTransactions(ctx, 11, 1, 0)
+	open, err = db.openTransactions(TransactionFilter{})
+	if err != nil || len(open) != 0 {
+		t.Fatalf("expected no open records, got %v %v", open, err)
+	}
+}
+
+func TestTransactionScope(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, _ := NewTestService()
+	svc.db = db
+	to := "0x0000000000000000000000000000000000000001"
+
+	alice := context.WithValue(context.Background(), userContextKey, "scope-alice@example.com")
+	from, err := svc.GenerateKey(alice)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	defer db.removeAccountUser(from, "scope-alice@example.com")
+	txHash, err := svc.ExecuteTransaction(alice, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	bob := context.WithValue(context.Background(), userContextKey, "scope-bob@example.com")
+	bob = context.WithValue(bob, permissionsContextKey, User{Email: "scope-bob@example.com", Role: RoleSigner})
+	if record, err := svc.GetTransaction(bob, txHash); err != nil || record != nil {
+		t.Fatalf("expected another user's transaction to be hidden, got %+v %v", record, err)
+	}
+	if records, err := svc.ListTransactions(bob, TransactionFilter{}); err != nil || len(records) != 0 {
+		t.Fatalf("expected no visible records, got %v %v", records, err)
+	}
+
+	// Users see the transactions of accounts they were granted
+	db.addAccountUser(from, "scope-bob@example.com")
+	defer db.removeAccountUser(from, "scope-bob@example.com")
+	if record, err := svc.GetTransaction(bob, txHash); err != nil || record == nil {
+		t.Fatalf("expected a granted account's transaction, got %+v %v", record, err)
+	}
+
+	admin := context.WithValue(context.Background(), userContextKey, "scope-admin@example.com")
+	admin = context.WithValue(admin, permissionsContextKey, User{Email: "scope-admin@example.com", Role: RoleAdmin})
+	if records, err := svc.ListTransactions(admin, TransactionFilter{Limit: 1}); err != nil || len(records) != 1 {
+		t.Fatalf("expected an admin to see every record, got %v %v", records, err)
+	}
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"log"
+	"math/big"
 	"net/http"
 	"os"
 
 	"github.com/eximchain/eth-client/quorum"
-	"github.com/eximchain/go-ethereum/accounts"
 	"github.com/eximchain/go-ethereum/accounts/keystore"
 )
 
@@ -24,16 +24,18 @@ func main() {
 		}
 
 		svc := transactionExecutorService{
-			keystore:      keystore.NewKeyStore("./keystore-local", keystore.StandardScryptN, keystore.StandardScryptP),
+			signer:        newKeystoreSigner(keystore.NewKeyStore("./keystore-local", keystore.StandardScryptN, keystore.StandardScryptP)),
 			quorumAddress: quorumAddress,
+			nonces:        newNonceManager(quorumClient, nil),
 			quorumClient:  quorumClient,
-			accountCache:  make(map[string]accounts.Account),
+			gas:           gasConfig{multiplier: defaultGasMultiplier, fixedPrice: big.NewInt(0)},
 		}
 
 		handler := new(http.Handler)
-		*handler = MakeRPCHandler(svc)
+		*handler = MakeRPCHandler(svc, defaultBatchConcurrency)
 
 		http.Handle("/", accessControl(*handler))
+		http.Handle("/ws", accessControl(MakeWSHandler(svc, defaultBatchConcurrency, wsConfig{maxSubscriptions: defaultMaxSubscriptions})))
 
 		log.Fatal(http.ListenAndServe(":8080", nil))
 	}
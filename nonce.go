@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eximchain/eth-client/quorum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+)
+
+// nonceManager hands out nonces for the executor's accounts so that
+// concurrent transactions from the same account never share a nonce.
+//
+// An account is synchronised with the node the first time it is used: the
+// next nonce is the larger of the node's pending nonce and the last nonce
+// persisted in the database. Nonces persisted but unknown to the node are
+// treated as gaps and handed out again before any new nonce, unless the
+// journal still holds a pending or signed transaction with that nonce.
+type nonceManager struct {
+	mu     sync.Mutex
+	client quorum.Client
+	db     *BoltDB
+
+	next map[ethCommon.Address]uint64
+	// Nonces that were handed out but never reached the node, lowest first
+	gaps map[ethCommon.Address][]uint64
+}
+
+func newNonceManager(client quorum.Client, db *BoltDB) *nonceManager {
+	return &nonceManager{
+		client: client,
+		db:     db,
+		next:   make(map[ethCommon.Address]uint64),
+		gaps:   make(map[ethCommon.Address][]uint64),
+	}
+}
+
+// Next reserves a nonce for the address
+func (nm *nonceManager) Next(ctx context.Context, address ethCommon.Address) (uint64, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if _, synced := nm.next[address]; !synced {
+		if err := nm.sync(ctx, address); err != nil {
+			return 0, err
+		}
+	}
+
+	if gaps := nm.gaps[address]; len(gaps) > 0 {
+		nm.gaps[address] = gaps[1:]
+		return gaps[0], nil
+	}
+
+	nonce := nm.next[address]
+	nm.next[address] = nonce + 1
+
+	if nm.db != nil {
+		if err := nm.db.putNonce(address.Bytes(), nonce); err != nil {
+			log.Println("Error: persisting nonce", err)
+		}
+	}
+
+	return nonce, nil
+}
+
+// Peek returns the nonce Next would hand out without reserving it
+func (nm *nonceManager) Peek(ctx context.Context, address ethCommon.Address) (uint64, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if _, synced := nm.next[address]; !synced {
+		if err := nm.sync(ctx, address); err != nil {
+			return 0, err
+		}
+	}
+
+	if gaps := nm.gaps[address]; len(gaps) > 0 {
+		return gaps[0], nil
+	}
+
+	return nm.next[address], nil
+}
+
+// Reserve claims a nonce chosen by the caller. Nonces the node has already
+// mined are rejected. Any nonces skipped over become gaps. It reports whether
+// the nonce had already been handed out, in which case the new transaction
+// can only replace the one that used it.
+func (nm *nonceManager) Reserve(ctx context.Context, address ethCommon.Address, nonce uint64) (bool, error) {
+	mined, err := nm.client.NonceAt(ctx, address, nil)
+	if err != nil {
+		return false, err
+	}
+	if nonce < mined {
+		return false, ErrNonceTooLow
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if _, synced := nm.next[address]; !synced {
+		if err := nm.sync(ctx, address); err != nil {
+			return false, err
+		}
+	}
+
+	gaps := nm.gaps[address]
+	for i, gap := range gaps {
+		if gap == nonce {
+			nm.gaps[address] = append(gaps[:i:i], gaps[i+1:]...)
+			return false, nil
+		}
+	}
+
+	next := nm.next[address]
+	if nonce < next {
+		return true, nil
+	}
+
+	for n := next; n < nonce; n++ {
+		nm.addGap(address, n)
+	}
+	nm.next[address] = nonce + 1
+
+	if nm.db != nil {
+		if err := nm.db.putNonce(address.Bytes(), nonce); err != nil {
+			log.Println("Error: persisting nonce", err)
+		}
+	}
+
+	return false, nil
+}
+
+// Release returns a reserved nonce that was not used, e.g. because signing or
+// sending failed, so that the next transaction fills the gap.
+func (nm *nonceManager) Release(address ethCommon.Address, nonce uint64) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	next, synced := nm.next[address]
+	if !synced || nonce >= next {
+		return
+	}
+
+	if nonce+1 == next && len(nm.gaps[address]) == 0 {
+		nm.next[address] = nonce
+		if nm.db != nil && nonce > 0 {
+			if err := nm.db.putNonce(address.Bytes(), nonce-1); err != nil {
+				log.Println("Error: persisting nonce", err)
+			}
+		}
+		return
+	}
+
+	nm.addGap(address, nonce)
+}
+
+// Resync catches up with nonces the node has seen from elsewhere. Nonces
+// still reserved by other transactions are kept, as are gaps the node has not
+// passed yet.
+func (nm *nonceManager) Resync(ctx context.Context, address ethCommon.Address) error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	pending, err := nm.client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	if next := nm.next[address]; next > pending {
+		gaps := nm.gaps[address]
+		i := sort.Search(len(gaps), func(i int) bool { return gaps[i] >= pending })
+		nm.gaps[address] = gaps[i:]
+		return nil
+	}
+
+	delete(nm.gaps, address)
+	nm.next[address] = pending
+	if nm.db != nil && pending > 0 {
+		if err := nm.db.putNonce(address.Bytes(), pending-1); err != nil {
+			log.Println("Error: persisting nonce", err)
+		}
+	}
+
+	return nil
+}
+
+func (nm *nonceManager) sync(ctx context.Context, address ethCommon.Address) error {
+	pending, err := nm.client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	next := pending
+	if nm.db != nil {
+		last, found, err := nm.db.getNonce(address.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if found && last+1 > pending {
+			log.Printf("Nonce gap detected for %s: node pending nonce %d, last used %d", address.Hex(), pending, last)
+			// Transactions still in the journal are being rebroadcast or may
+			// be sent by the client that signed them
+			open, err := nm.db.openNonces(address.Hex())
+			if err != nil {
+				return err
+			}
+			for n := pending; n <= last; n++ {
+				if !open[n] {
+					nm.addGap(address, n)
+				}
+			}
+			next = last + 1
+		}
+	}
+
+	nm.next[address] = next
+	return nil
+}
+
+func (nm *nonceManager) addGap(address ethCommon.Address, nonce uint64) {
+	gaps := nm.gaps[address]
+	i := sort.Search(len(gaps), func(i int) bool { return gaps[i] >= nonce })
+	if i < len(gaps) && gaps[i] == nonce {
+		return
+	}
+
+	gaps = append(gaps, 0)
+	copy(gaps[i+1:], gaps[i:])
+	gaps[i] = nonce
+	nm.gaps[address] = gaps
+}
+
+// isNonceTooLow reports whether the node rejected a transaction because its
+// nonce has already been used
+func isNonceTooLow(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "nonce too low")
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+func TestNonceManagerConcurrent(t *testing.T) {
+	q := newFakeQuorum()
+	address := ethCommon.HexToAddress("0x10")
+	q.nonces[address] = 7
+
+	nm := newNonceManager(q, nil)
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := nm.Next(context.Background(), address)
+			if err != nil {
+				t.Errorf("cannot get nonce %s", err)
+				return
+			}
+			mu.Lock()
+			seen[nonce] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for n := uint64(7); n < 57; n++ {
+		if !seen[n] {
+			t.Fatalf("nonce %d not handed out", n)
+		}
+	}
+}
+
+func TestNonceManagerRelease(t *testing.T) {
+	q := newFakeQuorum()
+	address := ethCommon.HexToAddress("0x11")
+	nm := newNonceManager(q, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		nm.Next(ctx, address)
+	}
+
+	// Releasing a nonce in the middle leaves a gap to fill first
+	nm.Release(address, 1)
+	if nonce, _ := nm.Next(ctx, address); nonce != 1 {
+		t.Fatalf("expected gap nonce 1, got %d", nonce)
+	}
+	if nonce, _ := nm.Next(ctx, address); nonce != 3 {
+		t.Fatalf("expected nonce 3, got %d", nonce)
+	}
+
+	// Releasing the latest nonce rewinds
+	nm.Release(address, 3)
+	if nonce, _ := nm.Next(ctx, address); nonce != 3 {
+		t.Fatalf("expected nonce 3 again, got %d", nonce)
+	}
+
+	q.nonces[address] = 10
+	if err := nm.Resync(ctx, address); err != nil {
+		t.Fatalf("cannot resync %s", err)
+	}
+	if nonce, _ := nm.Next(ctx, address); nonce != 10 {
+		t.Fatalf("expected resynced nonce 10, got %d", nonce)
+	}
+}
+
+func TestNonceManagerResyncConcurrent(t *testing.T) {
+	q := newFakeQuorum()
+	address := ethCommon.HexToAddress("0x13")
+	nm := newNonceManager(q, nil)
+	ctx := context.Background()
+
+	// Nonces 0-9 are held by transactions still being signed, and 4 and 8
+	// were released
+	for i := 0; i < 10; i++ {
+		nm.Next(ctx, address)
+	}
+	nm.Release(address, 4)
+	nm.Release(address, 8)
+
+	// Another client used nonces up to 5, so a send with nonce 4 was too low
+	q.nonces[address] = 6
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 0 {
+				if err := nm.Resync(ctx, address); err != nil {
+					t.Errorf("cannot resync %s", err)
+				}
+				return
+			}
+			nonce, err := nm.Next(ctx, address)
+			if err != nil {
+				t.Errorf("cannot get nonce %s", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[nonce] {
+				t.Errorf("nonce %d handed out twice", nonce)
+			}
+			seen[nonce] = true
+		}(i)
+	}
+	wg.Wait()
+
+	for n := uint64(0); n < 10; n++ {
+		if seen[n] && n != 4 && n != 8 {
+			t.Fatalf("reserved nonce %d handed out again", n)
+		}
+	}
+	if !seen[8] {
+		t.Fatal("gap 8 above the node's pending nonce not handed out")
+	}
+}
+
+func TestNonceManagerPersistence(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	q := newFakeQuorum()
+	address := ethCommon.HexToAddress("0x12")
+	ctx := context.Background()
+
+	// Clear any state left by earlier runs
+	nm := newNonceManager(q, db)
+	if err := nm.Resync(ctx, address); err != nil {
+		t.Fatalf("cannot resync %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		nm.Next(ctx, address)
+	}
+
+	// The node only saw the first transaction; nonces 1 and 2 are gaps
+	q.nonces[address] = 1
+	nm = newNonceManager(q, db)
+
+	for _, expected := range []uint64{1, 2, 3} {
+		nonce, err := nm.Next(ctx, address)
+		if err != nil {
+			t.Fatalf("cannot get nonce %s", err)
+		}
+		if nonce != expected {
+			t.Fatalf("expected nonce %d, got %d", expected, nonce)
+		}
+	}
+}
+
+func TestNonceGapJournal(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	q := newFakeQuorum()
+	address := ethCommon.HexToAddress("0x13")
+	ctx := context.Background()
+
+	nm := newNonceManager(q, db)
+	if err := nm.Resync(ctx, address); err != nil {
+		t.Fatalf("cannot resync %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		nm.Next(ctx, address)
+	}
+
+	// Nonce 1 is still pending in the journal, so only 2 is a gap
+	tx := types.NewTransaction(1, address, big.NewInt(1), 21000, big.NewInt(1), nil)
+	record, err := newTransactionRecord(ctx, tx, address, TxPending)
+	if err != nil {
+		t.Fatalf("cannot create record %s", err)
+	}
+	if err := db.putTransaction(record); err != nil {
+		t.Fatalf("cannot store record %s", err)
+	}
+
+	q.nonces[address] = 1
+	nm = newNonceManager(q, db)
+
+	for _, expected := range []uint64{2, 3} {
+		nonce, err := nm.Next(ctx, address)
+		if err != nil {
+			t.Fatalf("cannot get nonce %s", err)
+		}
+		if nonce != expected {
+			t.Fatalf("expected nonce %d, got %d", expected, nonce)
+		}
+	}
+}
+
+func TestIsNonceTooLow(t *testing.T) {
+	if !isNonceTooLow(errors.New("nonce too low")) {
+		t.Fatal("nonce too low not detected")
+	}
+
+	if isNonceTooLow(nil) || isNonceTooLow(errors.New("insufficient funds")) {
+		t.Fatal("unexpected nonce too low")
+	}
+}
+
+func TestExplicitNonce(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	signedNonce := func(nonce *uint64) (uint64, error) {
+		res, err := svc.EthSignTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nonce)
+		if err != nil {
+			return 0, err
+		}
+		tx := new(types.Transaction)
+		if err := ethRlp.DecodeBytes(ethCommon.FromHex(res.(string)), tx); err != nil {
+			t.Fatalf("cannot decode transaction %s", err)
+		}
+		return tx.Nonce(), nil
+	}
+
+	address := ethCommon.HexToAddress(from)
+	reservedNonce := func(nonce *uint64) (uint64, *TransactionRecord, error) {
+		return svc.reserveNonce(ctx, address, nonce)
+	}
+
+	// Skipping ahead leaves gaps that are filled first
+	two := uint64(2)
+	if nonce, _, err := reservedNonce(&two); err != nil || nonce != 2 {
+		t.Fatalf("expected nonce 2, got %d %v", nonce, err)
+	}
+	for _, expected := range []uint64{0, 1, 3} {
+		if nonce, _, err := reservedNonce(nil); err != nil || nonce != expected {
+			t.Fatalf("expected nonce %d, got %d %v", expected, nonce, err)
+		}
+	}
+
+	// Signing only takes the next nonce without reserving it
+	for i := 0; i < 2; i++ {
+		if nonce, err := signedNonce(nil); err != nil || nonce != 4 {
+			t.Fatalf("expected signed nonce 4, got %d %v", nonce, err)
+		}
+	}
+	if nonce, _, err := reservedNonce(nil); err != nil || nonce != 4 {
+		t.Fatalf("expected nonce 4 after signing, got %d %v", nonce, err)
+	}
+
+	// A signed transaction's nonce can be reused to replace it
+	if nonce, err := signedNonce(&two); err != nil || nonce != 2 {
+		t.Fatalf("expected signed nonce 2, got %d %v", nonce, err)
+	}
+	if nonce, replaces, err := reservedNonce(&two); err != nil || nonce != 2 || replaces == nil || uint64(replaces.Nonce) != 2 {
+		t.Fatalf("expected nonce 2 replacing the signed transaction, got %d %+v %v", nonce, replaces, err)
+	}
+
+	q.mined[address] = 2
+	one := uint64(1)
+	if _, _, err := reservedNonce(&one); err != ErrNonceTooLow {
+		t.Fatalf("expected %s, got %v", ErrNonceTooLow, err)
+	}
+	if _, err := signedNonce(&one); err != ErrNonceTooLow {
+		t.Fatalf("expected %s signing, got %v", ErrNonceTooLow, err)
+	}
+
+	// Without a journal a reused nonce cannot be checked
+	svc.db = nil
+	three := uint64(3)
+	if _, _, err := reservedNonce(&three); err != ErrNonceInUse {
+		t.Fatalf("expected %s, got %v", ErrNonceInUse, err)
+	}
+}
+
+func TestExplicitNonceReplacement(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, _ := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(1), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	zero := uint64(0)
+	replacementHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(2), 21000, big.NewInt(2), "", &zero)
+	if err != nil {
+		t.Fatalf("cannot replace transaction %s", err)
+	}
+
+	record, err := db.getTransaction(txHash)
+	if err != nil || record.Status != TxReplaced || record.ReplacedBy != replacementHash {
+		t.Fatalf("expected replaced record, got %+v %v", record, err)
+	}
+
+	replacement, err := db.getTransaction(replacementHash)
+	if err != nil || replacement.Status != TxPending || replacement.Replaces != txHash {
+		t.Fatalf("unexpected replacement record %+v %v", replacement, err)
+	}
+}
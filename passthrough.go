@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// interceptedMethods are never forwarded to the node, whatever the allowlist
+// says, since they would sign with or expose the node's own accounts, send
+// private transactions around the executor's checks, or manage the node
+var interceptedMethods = []string{
+	"eth_sign*",
+	"eth_sendTransaction*",
+	"eth_*PrivateTransaction",
+	"personal_*",
+	"admin_*",
+	"debug_*",
+	"miner_*",
+	"raft_*",
+	"clique_*",
+	"istanbul_*",
+}
+
+// methodFilter decides which methods without a local handler are passed
+// through to the node. Entries are method names or path.Match glob patterns.
+// Only methods on the allowlist are passed through.
+type methodFilter struct {
+	allow []string
+	deny  []string
+}
+
+// newMethodFilter parses comma-separated allow and deny lists
+func newMethodFilter(allow string, deny string) *methodFilter {
+	return &methodFilter{allow: splitMethodList(allow), deny: splitMethodList(deny)}
+}
+
+func splitMethodList(list string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns
+}
+
+func (f *methodFilter) allowed(method string) bool {
+	if matchMethod(interceptedMethods, method) || matchMethod(f.deny, method) {
+		return false
+	}
+
+	return matchMethod(f.allow, method)
+}
+
+func matchMethod(patterns []string, method string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, method); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Passthrough forwards a call verbatim to the node and returns its raw result.
+// Errors from the node are returned as jsonrpc.Error with its code.
+func (svc transactionExecutorService) Passthrough(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	if svc.passthroughMethods == nil || !svc.passthroughMethods.allowed(method) {
+		return nil, errMethodNotFound(method)
+	}
+
+	if len(params) == 0 {
+		params = json.RawMessage("[]")
+	}
+
+	u, _ := url.Parse(svc.quorumAddress)
+	client := jsonrpc.NewClient(u, method, jsonrpc.ClientResponseDecoder(decodeRawRPCResponse))
+	res, err := client.Endpoint()(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(json.RawMessage), nil
+}
+
+func decodeRawRPCResponse(_ context.Context, res jsonrpc.Response) (interface{}, error) {
+	if res.Error != nil {
+		return nil, *res.Error
+	}
+
+	if len(res.Result) == 0 {
+		return json.RawMessage("null"), nil
+	}
+
+	return res.Result, nil
+}
+
+func errMethodNotFound(method string) jsonrpc.Error {
+	return jsonrpc.Error{Code: jsonrpc.MethodNotFoundError, Message: "Method " + method + " was not found."}
+}
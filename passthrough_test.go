@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeNode answers every call with its method and params, except
+// eth_fail, eth_busy and eth_call which return node errors
+func newFakeNode(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode node request %s", err)
+			return
+		}
+
+		res := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_fail":
+			res["error"] = map[string]interface{}{"code": -32010, "message": "node says no"}
+		case "eth_busy":
+			res["error"] = map[string]interface{}{"code": -32005, "message": "node is busy"}
+		case "eth_call":
+			res["error"] = map[string]interface{}{"code": 3, "message": "execution reverted", "data": "0x08c379a0"}
+		default:
+			res["result"] = map[string]interface{}{"method": req.Method, "params": req.Params}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+}
+
+func TestMethodFilter(t *testing.T) {
+	f := newMethodFilter("eth_*, txpool_content", "eth_getWork")
+
+	for method, expected := range map[string]bool{
+		"eth_chainId":                   true,
+		"txpool_content":                true,
+		"txpool_inspect":                false,
+		"eth_getWork":                   false,
+		"eth_signTypedData":             false,
+		"eth_sendTransaction":           false,
+		"eth_sendTransactionAsync":      false,
+		"eth_sendRawPrivateTransaction": false,
+		"personal_sign":                 false,
+	} {
+		if f.allowed(method) != expected {
+			t.Fatalf("expected %s allowed to be %v", method, expected)
+		}
+	}
+
+	if newMethodFilter("", "").allowed("eth_chainId") {
+		t.Fatal("expected empty allowlist to allow nothing")
+	}
+
+	all := newMethodFilter("*", "")
+	for _, method := range []string{"admin_addPeer", "debug_traceTransaction", "miner_start", "raft_addPeer", "clique_propose", "istanbul_propose"} {
+		if all.allowed(method) {
+			t.Fatalf("expected %s never to be forwarded", method)
+		}
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	svc, _ := NewTestService()
+	svc.quorumAddress = node.URL
+	svc.passthroughMethods = newMethodFilter("eth_*,txpool_*,debug_*", "")
+
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	var res testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"txpool_content","params":[1,{"a":"b"}]}`), &res)
+	if res.Error != nil || string(res.Result) != `{"method":"txpool_content","params":[1,{"a":"b"}]}` {
+		t.Fatalf("unexpected passthrough response %s %+v", res.Result, res.Error)
+	}
+
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction","params":[]}`), &res)
+	if res.Error == nil || res.Error.Code != -32601 {
+		t.Fatalf("expected intercepted method to be not found, got %+v", res)
+	}
+
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":3,"method":"eth_fail","params":[]}`), &res)
+	if res.Error == nil || res.Error.Code != -32010 || res.Error.Message != "node says no" {
+		t.Fatalf("expected node error, got %+v", res)
+	}
+
+	// The node's own limits are not the user's, so they get no HTTP 429
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_busy","params":[]}`))
+	if err != nil {
+		t.Fatalf("cannot post %s", err)
+	}
+	res = testRPCResponse{}
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || res.Error == nil || res.Error.Code != -32005 {
+		t.Fatalf("expected the node's limit error with status 200, got %s %+v", resp.Status, res.Error)
+	}
+
+	// Locally handled methods are not forwarded
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":4,"method":"personal_newAccount","params":[""]}`), &res)
+	if res.Error != nil || len(res.Result) != 44 {
+		t.Fatalf("unexpected local response %+v", res)
+	}
+
+	var responses []testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, `[{"jsonrpc":"2.0","id":5,"method":"eth_chainId","params":[]},{"jsonrpc":"2.0","id":6,"method":"personal_sign","params":[]}]`), &responses)
+	if len(responses) != 2 || responses[0].Error != nil || responses[1].Error == nil {
+		t.Fatalf("unexpected batch responses %+v", responses)
+	}
+}
+
+func TestProxiedNodeError(t *testing.T) {
+	node := newFakeNode(t)
+	defer node.Close()
+
+	svc, _ := NewTestService()
+	svc.quorumAddress = node.URL
+
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	var res struct {
+		Error *struct {
+			Code    int       `json:"code"`
+			Message string    `json:"message"`
+			Data    NodeError `json:"data"`
+		} `json:"error"`
+	}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{},"latest"]}`), &res)
+	if res.Error == nil || res.Error.Code != ErrCodeServer {
+		t.Fatalf("expected a quorum error, got %+v", res.Error)
+	}
+	data := res.Error.Data
+	if data.Code != 3 || data.Message != "execution reverted" || data.Data != "0x08c379a0" {
+		t.Fatalf("expected the node's error in the data, got %+v", data)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eximchain/go-ethereum/accounts/keystore"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/crypto"
+)
+
+// defaultUnlockDuration is used by personal_unlockAccount without a duration, as in geth
+const defaultUnlockDuration = 300 * time.Second
+
+// PersonalNewAccount creates an account protected by the passphrase
+func (svc transactionExecutorService) PersonalNewAccount(ctx context.Context, passphrase string) (string, error) {
+	account, err := svc.signer.NewAccount(passphrase)
+	if err == ErrPassphraseUnsupported {
+		return "", err
+	}
+	if err != nil {
+		log.Println(err)
+		return "", ErrKeystore
+	}
+
+	address := "0x" + hex.EncodeToString(account.Address.Bytes())
+	svc.recordAccountUser(ctx, address)
+	return address, nil
+}
+
+// PersonalListAccounts returns the addresses of the accounts the signer holds
+// that the caller may see
+func (svc transactionExecutorService) PersonalListAccounts(ctx context.Context) ([]string, error) {
+	accs, err := svc.signer.Accounts()
+	if err != nil {
+		log.Println("Error: Accounts")
+		log.Println(err)
+		return nil, ErrKeystore
+	}
+
+	addresses := make([]string, len(accs))
+	for i, account := range accs {
+		addresses[i] = strings.ToLower(account.Address.Hex())
+	}
+
+	return svc.visibleAccounts(ctx, addresses)
+}
+
+// PersonalSign signs data with the eth_sign prefix, decrypting the key with
+// the passphrase rather than requiring the account to be unlocked
+func (svc transactionExecutorService) PersonalSign(ctx context.Context, data string, address string, passphrase string) (string, error) {
+	account, err := svc.userAccount(ctx, address)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := svc.signer.SignHashWithPassphrase(account, passphrase, signHash(ethCommon.FromHex(data)))
+	if err != nil {
+		return "", signerError(err)
+	}
+
+	signature[64] += 27
+
+	return ethCommon.ToHex(signature), nil
+}
+
+// PersonalEcRecover returns the address that produced a personal_sign or
+// eth_sign signature of data
+func (svc transactionExecutorService) PersonalEcRecover(_ context.Context, data string, sig string) (string, error) {
+	signature := ethCommon.FromHex(sig)
+	if len(signature) != 65 {
+		return "", invalidParams("signature: must be 65 bytes")
+	}
+	if signature[64] != 27 && signature[64] != 28 {
+		return "", invalidParams("signature: v must be 27 or 28")
+	}
+
+	recoverable := make([]byte, 65)
+	copy(recoverable, signature)
+	recoverable[64] -= 27
+
+	pub, err := crypto.SigToPub(signHash(ethCommon.FromHex(data)), recoverable)
+	if err != nil {
+		return "", invalidParams("signature: " + err.Error())
+	}
+
+	return strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()), nil
+}
+
+// PersonalImportRawKey adds a hex encoded private key to the signer, protected by the passphrase
+func (svc transactionExecutorService) PersonalImportRawKey(ctx context.Context, hexKey string, passphrase string) (string, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return "", invalidParams("key: " + err.Error())
+	}
+
+	account, err := svc.signer.ImportKey(key, passphrase)
+	if err == ErrAccountExists || err == ErrPassphraseUnsupported {
+		return "", err
+	}
+	if err != nil {
+		log.Println(err)
+		return "", ErrKeystore
+	}
+
+	address := "0x" + hex.EncodeToString(account.Address.Bytes())
+	svc.recordAccountUser(ctx, address)
+	return address, nil
+}
+
+// PersonalUnlockAccount lets the executor sign with an account for the
+// duration, or until it is locked if the duration is zero
+func (svc transactionExecutorService) PersonalUnlockAccount(ctx context.Context, address string, passphrase string, duration time.Duration) (bool, error) {
+	account, err := svc.userAccount(ctx, address)
+	if err != nil {
+		return false, err
+	}
+
+	if err := svc.signer.Unlock(account, passphrase, duration); err != nil {
+		return false, signerError(err)
+	}
+
+	return true, nil
+}
+
+// PersonalLockAccount removes an unlocked account's key from memory
+func (svc transactionExecutorService) PersonalLockAccount(ctx context.Context, address string) (bool, error) {
+	account, err := svc.userAccount(ctx, address)
+	if err != nil {
+		return false, err
+	}
+
+	if err := svc.signer.Lock(account.Address); err != nil {
+		log.Println(err)
+		return false, ErrKeystore
+	}
+
+	return true, nil
+}
+
+// signerError converts an error from the signer to the one returned to callers
+func signerError(err error) error {
+	switch err {
+	case keystore.ErrLocked:
+		return ErrAccountLocked
+	case keystore.ErrDecrypt:
+		return ErrPassphrase
+	case ErrAccountMissing, ErrPassphraseUnsupported:
+		return err
+	}
+
+	log.Println("Error: Signing")
+	log.Println(err)
+	return ErrSigning
+}
+
+// ErrAccountLocked is returned when signing with an account that has a passphrase and is not unlocked
+var ErrAccountLocked = newRPCError(ErrCodeServer, "authentication needed: passphrase or unlock")
+
+// ErrPassphrase is returned when the passphrase does not decrypt the account's key
+var ErrPassphrase = newRPCError(ErrCodeServer, "could not decrypt key with given passphrase")
+
+// ErrAccountExists is returned when importing a key the signer already holds
+var ErrAccountExists = newRPCError(ErrCodeServer, "account already exists")
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eximchain/go-ethereum/accounts/keystore"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+)
+
+func TestPersonalNamespace(t *testing.T) {
+	svc, _ := NewTestService()
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	call := func(method string, params string) testRPCResponse {
+		var res testRPCResponse
+		json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":`+params+`}`), &res)
+		return res
+	}
+
+	var address string
+	res := call("personal_newAccount", `["secret"]`)
+	if res.Error != nil || json.Unmarshal(res.Result, &address) != nil {
+		t.Fatalf("cannot create account %+v", res.Error)
+	}
+
+	if res := call("eth_sign", `["`+address+`","0x1234"]`); res.Error == nil || res.Error.Message != ErrAccountLocked.Message {
+		t.Fatalf("expected locked account, got %+v", res)
+	}
+	if res := call("personal_sign", `["0x1234","`+address+`","wrong"]`); res.Error == nil || res.Error.Message != ErrPassphrase.Message {
+		t.Fatalf("expected wrong passphrase, got %+v", res)
+	}
+
+	var signature string
+	res = call("personal_sign", `["0x1234","`+address+`","secret"]`)
+	if res.Error != nil || json.Unmarshal(res.Result, &signature) != nil {
+		t.Fatalf("cannot sign %+v", res.Error)
+	}
+
+	var recovered string
+	res = call("personal_ecRecover", `["0x1234","`+signature+`"]`)
+	if res.Error != nil || json.Unmarshal(res.Result, &recovered) != nil || recovered != address {
+		t.Fatalf("expected %s, recovered %s %+v", address, recovered, res.Error)
+	}
+
+	if res := call("personal_unlockAccount", `["`+address+`","wrong",null]`); res.Error == nil {
+		t.Fatal("expected unlock with the wrong passphrase to fail")
+	}
+	if res := call("personal_unlockAccount", `["`+address+`","secret",60]`); res.Error != nil || string(res.Result) != "true" {
+		t.Fatalf("cannot unlock %+v", res.Error)
+	}
+	if res := call("eth_sign", `["`+address+`","0x1234"]`); res.Error != nil || string(res.Result) != `"`+signature+`"` {
+		t.Fatalf("expected the personal_sign signature from an unlocked account, got %s %+v", res.Result, res.Error)
+	}
+	if res := call("personal_lockAccount", `["`+address+`"]`); res.Error != nil || string(res.Result) != "true" {
+		t.Fatalf("cannot lock %+v", res.Error)
+	}
+	if res := call("eth_sign", `["`+address+`","0x1234"]`); res.Error == nil {
+		t.Fatal("expected locked account")
+	}
+
+	// keccak256("cow")
+	key := "0xc85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4"
+	res = call("personal_importRawKey", `["`+key+`",""]`)
+	if res.Error != nil || string(res.Result) != `"0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826"` {
+		t.Fatalf("unexpected import %s %+v", res.Result, res.Error)
+	}
+	if res := call("personal_importRawKey", `["`+key+`",""]`); res.Error == nil || res.Error.Message != ErrAccountExists.Message {
+		t.Fatalf("expected duplicate import to fail, got %+v", res)
+	}
+	if res := call("personal_importRawKey", `["0x1234"]`); res.Error == nil || res.Error.Code != -32602 {
+		t.Fatalf("expected invalid key, got %+v", res)
+	}
+
+	var accounts []string
+	res = call("personal_listAccounts", `[]`)
+	if res.Error != nil || json.Unmarshal(res.Result, &accounts) != nil || len(accounts) != 2 {
+		t.Fatalf("unexpected accounts %s %+v", res.Result, res.Error)
+	}
+}
+
+func TestTimedUnlock(t *testing.T) {
+	signer := newMemorySigner()
+	account, err := signer.NewAccount("secret")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	if err := signer.Unlock(account, "secret", 10*time.Millisecond); err != nil {
+		t.Fatalf("cannot unlock %s", err)
+	}
+	if _, err := signer.SignHash(account, signHash(nil)); err != nil {
+		t.Fatalf("cannot sign with unlocked account %s", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := signer.SignHash(account, signHash(nil)); err != keystore.ErrLocked {
+		t.Fatalf("expected account to lock again, got %v", err)
+	}
+}
+
+func TestKeystoreSignerPassphrases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatalf("cannot create keystore dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	signer := newKeystoreSigner(keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP))
+	tx := types.NewTransaction(0, ethCommon.HexToAddress("0x1"), big.NewInt(1), 21000, big.NewInt(0), nil)
+
+	// Accounts without a passphrase sign without being unlocked
+	legacy, err := signer.NewAccount("")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+	if _, err := signer.SignTx(legacy, tx, nil); err != nil {
+		t.Fatalf("cannot sign with account without passphrase %s", err)
+	}
+
+	account, err := signer.NewAccount("secret")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+	if _, err := signer.SignTx(account, tx, nil); err != keystore.ErrLocked {
+		t.Fatalf("expected locked account, got %v", err)
+	}
+	if err := signer.Unlock(account, "wrong", 0); err != keystore.ErrDecrypt {
+		t.Fatalf("expected wrong passphrase, got %v", err)
+	}
+	if err := signer.Unlock(account, "secret", 0); err != nil {
+		t.Fatalf("cannot unlock %s", err)
+	}
+	if _, err := signer.SignTx(account, tx, nil); err != nil {
+		t.Fatalf("cannot sign with unlocked account %s", err)
+	}
+
+	signer.Lock(account.Address)
+	if _, err := signer.SignHash(account, signHash(nil)); err != keystore.ErrLocked {
+		t.Fatalf("expected locked account, got %v", err)
+	}
+
+	// Accounts from before the signer started are only tried with the empty
+	// passphrase once
+	restarted := newKeystoreSigner(keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP))
+	if _, err := restarted.SignTx(legacy, tx, nil); err != nil {
+		t.Fatalf("cannot sign with account without passphrase after restart %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := restarted.SignTx(account, tx, nil); err != keystore.ErrLocked {
+			t.Fatalf("expected locked account after restart, got %v", err)
+		}
+	}
+	if empty, known := restarted.emptyPassphrase[account.Address]; !known || empty {
+		t.Fatal("expected the account's passphrase to be recorded as set")
+	}
+
+	svc, _ := NewTestService()
+	svc.signer = signer
+	if _, err := svc.ExecuteTransaction(context.Background(), account.Address.Hex(), "0x0000000000000000000000000000000000000001", big.NewInt(1), 21000, big.NewInt(0), "", nil); err != ErrAccountLocked {
+		t.Fatalf("expected %s, got %v", ErrAccountLocked, err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+)
+
+// Policy limits the transactions the executor signs for an account or a
+// user. Empty fields do not limit anything.
+type Policy struct {
+	// Largest value of one transaction, and of all transactions in a UTC day
+	MaxValue      *big.Int `json:"maxValue,omitempty"`
+	MaxDailyValue *big.Int `json:"maxDailyValue,omitempty"`
+	// Addresses transactions may be sent to. Contracts cannot be created if set.
+	To []string `json:"to,omitempty"`
+	// Method selectors that transactions with data may call
+	Selectors   []string `json:"selectors,omitempty"`
+	MaxGas      uint64   `json:"maxGas,omitempty"`
+	MaxGasPrice *big.Int `json:"maxGasPrice,omitempty"`
+	// Addresses transactions may never be sent to
+	Deny []string `json:"deny,omitempty"`
+}
+
+// PolicyViolation is the data of errors for transactions a policy forbids
+type PolicyViolation struct {
+	Policy string `json:"policy"`
+	Rule   string `json:"rule"`
+}
+
+// policyTx is the part of a transaction policies judge
+type policyTx struct {
+	to       string
+	value    *big.Int
+	gas      uint64
+	gasPrice *big.Int
+	data     []byte
+}
+
+// Policies are stored under the scope they apply to
+func accountPolicyScope(address string) string {
+	return "account:" + strings.ToLower(address)
+}
+
+func userPolicyScope(email string) string {
+	return "user:" + email
+}
+
+// validate checks the addresses and selectors of a policy
+func (p Policy) validate() error {
+	for _, list := range [][]string{p.To, p.Deny} {
+		for _, address := range list {
+			if !isHexAddress(address) {
+				return fmt.Errorf("invalid address %s", address)
+			}
+		}
+	}
+
+	for _, selector := range p.Selectors {
+		if !has0xPrefix(selector) || len(ethCommon.FromHex(selector)) != 4 {
+			return fmt.Errorf("invalid method selector %s: expected 4 hex bytes", selector)
+		}
+	}
+
+	for _, amount := range []*big.Int{p.MaxValue, p.MaxDailyValue, p.MaxGasPrice} {
+		if amount != nil && amount.Sign() < 0 {
+			return fmt.Errorf("invalid negative limit %s", amount)
+		}
+	}
+
+	return nil
+}
+
+// check returns the rule a transaction breaks and why, or an empty rule if
+// the policy allows it. The daily value is checked when it is spent.
+func (p Policy) check(tx policyTx) (string, string) {
+	if tx.to != "" && containsAddress(p.Deny, tx.to) {
+		return "deny", fmt.Sprintf("destination %s is denied", strings.ToLower(tx.to))
+	}
+
+	if len(p.To) > 0 {
+		if tx.to == "" {
+			return "to", "contract creation is not allowed"
+		}
+		if !containsAddress(p.To, tx.to) {
+			return "to", fmt.Sprintf("destination %s is not allowed", strings.ToLower(tx.to))
+		}
+	}
+
+	if len(p.Selectors) > 0 && len(tx.data) > 0 {
+		if len(tx.data) < 4 {
+			return "selectors", "data is too short for a method selector"
+		}
+		selector := ethCommon.ToHex(tx.data[:4])
+		allowed := false
+		for _, s := range p.Selectors {
+			allowed = allowed || strings.EqualFold(s, selector)
+		}
+		if !allowed {
+			return "selectors", fmt.Sprintf("method selector %s is not allowed", selector)
+		}
+	}
+
+	if p.MaxValue != nil && tx.value.Cmp(p.MaxValue) > 0 {
+		return "maxValue", fmt.Sprintf("value %s exceeds the maximum of %s per transaction", tx.value, p.MaxValue)
+	}
+
+	if p.MaxGas != 0 && tx.gas > p.MaxGas {
+		return "maxGas", fmt.Sprintf("gas limit %d exceeds the maximum of %d", tx.gas, p.MaxGas)
+	}
+
+	if p.MaxGasPrice != nil && tx.gasPrice != nil && tx.gasPrice.Cmp(p.MaxGasPrice) > 0 {
+		return "maxGasPrice", fmt.Sprintf("gas price %s exceeds the maximum of %s", tx.gasPrice, p.MaxGasPrice)
+	}
+
+	return "", ""
+}
+
+// checkPolicy evaluates the policies of the sending account and the
+// authenticated user before a transaction is signed. If spend is set the
+// value counts towards their daily limits, and the returned function takes it
+// back off again for a transaction that is not sent after all.
+func (svc transactionExecutorService) checkPolicy(ctx context.Context, from string, tx policyTx, spend bool) (func(), error) {
+	noRefund := func() {}
+	if svc.db == nil {
+		return noRefund, nil
+	}
+	if tx.value == nil {
+		tx.value = new(big.Int)
+	}
+
+	scopes := []string{accountPolicyScope(from)}
+	if user := userFromContext(ctx); user != "" {
+		scopes = append(scopes, userPolicyScope(user))
+	}
+
+	daily := map[string]*big.Int{}
+	for _, scope := range scopes {
+		p, err := svc.db.getPolicy(scope)
+		if err != nil {
+			log.Println("Error: getPolicy")
+			log.Println(err)
+			return noRefund, ErrDatabase
+		}
+		if p == nil {
+			continue
+		}
+
+		if rule, reason := p.check(tx); rule != "" {
+			return noRefund, policyViolation(scope, rule, reason)
+		}
+		if p.MaxDailyValue != nil {
+			daily[scope] = p.MaxDailyValue
+		}
+	}
+
+	if !spend || len(daily) == 0 {
+		return noRefund, nil
+	}
+
+	now := time.Now().UTC()
+	scope, err := svc.db.spendValue(daily, tx.value, now)
+	if err != nil {
+		log.Println("Error: spendValue")
+		log.Println(err)
+		return noRefund, ErrDatabase
+	}
+	if scope != "" {
+		return noRefund, policyViolation(scope, "maxDailyValue", fmt.Sprintf("value %s would exceed the maximum of %s per day", tx.value, daily[scope]))
+	}
+
+	spent := make([]string, 0, len(daily))
+	for scope := range daily {
+		spent = append(spent, scope)
+	}
+	return func() {
+		if err := svc.db.refundValue(spent, tx.value, now); err != nil {
+			log.Println("Error: refundValue")
+			log.Println(err)
+		}
+	}, nil
+}
+
+// checkRawPolicy runs checkPolicy with spend set for a raw transaction from
+// one of the signer's accounts, which eth_signTransaction did not charge.
+// Transactions from other accounts are left to the node.
+func (svc transactionExecutorService) checkRawPolicy(ctx context.Context, params interface{}) (func(), error) {
+	tx := decodeRawTransaction(params)
+	if tx == nil || isPrivate(tx) {
+		return func() {}, nil
+	}
+
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.NewEIP155Signer(tx.ChainId())
+	}
+	from, err := types.Sender(signer, tx)
+	if err != nil || !svc.signer.HasAddress(from) {
+		return func() {}, nil
+	}
+
+	to := ""
+	if tx.To() != nil {
+		to = tx.To().Hex()
+	}
+	return svc.checkPolicy(ctx, from.Hex(), policyTx{to, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data()}, true)
+}
+
+func policyViolation(scope string, rule string, reason string) error {
+	msg := fmt.Sprintf("%s of %s: %s", ErrPolicyViolation.Message, strings.Replace(scope, ":", " ", 1), reason)
+	return ErrPolicyViolation.derive(msg, PolicyViolation{Policy: scope, Rule: rule})
+}
+
+// ErrPolicyViolation is returned when an account's or user's policy forbids a transaction
+var ErrPolicyViolation = newRPCError(ErrCodeTransactionRejected, "transaction rejected by policy")
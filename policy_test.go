@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	allowed := "0x00000000000000000000000000000000000000aa"
+	denied := "0x00000000000000000000000000000000000000bb"
+	transfer := ethCommon.FromHex("0xa9059cbb0000")
+
+	for _, c := range []struct {
+		policy Policy
+		tx     policyTx
+		rule   string
+	}{
+		{Policy{}, policyTx{to: denied, value: big.NewInt(100)}, ""},
+		{Policy{Deny: []string{denied}}, policyTx{to: "0x" + strings.ToUpper(denied[2:]), value: big.NewInt(0)}, "deny"},
+		{Policy{To: []string{allowed}}, policyTx{to: allowed, value: big.NewInt(0)}, ""},
+		{Policy{To: []string{allowed}}, policyTx{to: denied, value: big.NewInt(0)}, "to"},
+		{Policy{To: []string{allowed}}, policyTx{value: big.NewInt(0), data: transfer}, "to"},
+		{Policy{Selectors: []string{"0xA9059CBB"}}, policyTx{to: allowed, value: big.NewInt(0), data: transfer}, ""},
+		{Policy{Selectors: []string{"0x095ea7b3"}}, policyTx{to: allowed, value: big.NewInt(0), data: transfer}, "selectors"},
+		{Policy{Selectors: []string{"0x095ea7b3"}}, policyTx{to: allowed, value: big.NewInt(1)}, ""},
+		{Policy{MaxValue: big.NewInt(10)}, policyTx{to: allowed, value: big.NewInt(11)}, "maxValue"},
+		{Policy{MaxGas: 21000}, policyTx{to: allowed, value: big.NewInt(0), gas: 21001}, "maxGas"},
+		{Policy{MaxGasPrice: big.NewInt(5)}, policyTx{to: allowed, value: big.NewInt(0), gasPrice: big.NewInt(6)}, "maxGasPrice"},
+	} {
+		if rule, reason := c.policy.check(c.tx); rule != c.rule {
+			t.Fatalf("expected rule %q for %+v, got %q %s", c.rule, c.tx, rule, reason)
+		}
+	}
+
+	if err := (Policy{Selectors: []string{"0xa9059c"}}).validate(); err == nil {
+		t.Fatal("expected a short selector to be invalid")
+	}
+	if err := (Policy{To: []string{"0x1234"}}).validate(); err == nil {
+		t.Fatal("expected an invalid address to be invalid")
+	}
+}
+
+func TestPolicyEnforcement(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	svc, _ := NewTestService()
+	svc.db = db
+	from, err := svc.PersonalNewAccount(context.Background(), "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+	to := "0x00000000000000000000000000000000000000aa"
+
+	var out bytes.Buffer
+	runUserCommand(db, &out, []string{"-account", from, "-policy", `{"maxValue":10,"maxDailyValue":15,"to":["` + to + `"]}`})
+	if !strings.HasPrefix(out.String(), accountPolicyScope(from)) {
+		t.Fatalf("cannot set policy %q", out.String())
+	}
+	defer db.deletePolicy(accountPolicyScope(from))
+
+	send := func(ctx context.Context, to string, value int64, gasPrice int64) error {
+		_, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(value), 21000, big.NewInt(gasPrice), "", nil)
+		return err
+	}
+
+	ctx := context.Background()
+	for _, value := range []int64{5, 10} {
+		if err := send(ctx, to, value, 1); err != nil {
+			t.Fatalf("expected a transaction within the policy, got %s", err)
+		}
+	}
+
+	for _, c := range []struct {
+		to    string
+		value int64
+		rule  string
+	}{
+		{"0x00000000000000000000000000000000000000bb", 1, "to"},
+		{to, 11, "maxValue"},
+		{to, 1, "maxDailyValue"},
+	} {
+		err := send(ctx, c.to, c.value, 1)
+		if !errors.Is(err, ErrPolicyViolation) || rpcError(err).Code != ErrCodeTransactionRejected {
+			t.Fatalf("expected %s, got %v", ErrPolicyViolation, err)
+		}
+		if violation := rpcError(err).Data.(PolicyViolation); violation.Rule != c.rule || violation.Policy != accountPolicyScope(from) {
+			t.Fatalf("expected rule %s, got %+v", c.rule, violation)
+		}
+	}
+
+	// The authenticated user's policy applies as well as the account's
+	out.Reset()
+	runUserCommand(db, &out, []string{"-email", "policy@example.com", "-policy", `{"maxGasPrice":1}`})
+	defer db.deletePolicy(userPolicyScope("policy@example.com"))
+	db.addAccountUser(from, "policy@example.com")
+	user := context.WithValue(ctx, userContextKey, "policy@example.com")
+	err = send(user, to, 0, 2)
+	if !errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), "user policy@example.com: gas price 2 exceeds the maximum of 1") {
+		t.Fatalf("expected the user's gas price cap, got %v", err)
+	}
+
+	out.Reset()
+	runUserCommand(db, &out, []string{"-email", "policy@example.com", "-policy", `{"maxGas":"x"}`})
+	if !strings.HasPrefix(out.String(), "invalid policy") {
+		t.Fatalf("expected an invalid policy, got %q", out.String())
+	}
+}
+
+func TestPolicyReplacement(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x00000000000000000000000000000000000000aa"
+
+	if err := db.putPolicy(accountPolicyScope(from), Policy{MaxDailyValue: big.NewInt(10), MaxGasPrice: big.NewInt(109)}); err != nil {
+		t.Fatalf("cannot set policy %s", err)
+	}
+	defer db.deletePolicy(accountPolicyScope(from))
+
+	// A transaction that is not sent gives its value back
+	q.sendErr = errors.New("insufficient funds")
+	if _, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(10), 21000, big.NewInt(100), "", nil); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+	q.sendErr = nil
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(10), 21000, big.NewInt(100), "", nil)
+	if err != nil {
+		t.Fatalf("expected the refunded value to be spendable, got %s", err)
+	}
+
+	// Bumping to 110 would break the gas price cap
+	svc.rebroadcastPending(ctx, rebroadcastConfig{bumpAfter: time.Nanosecond, bumpPercent: 10})
+	if len(q.sent) != 1 {
+		t.Fatalf("transaction replaced beyond the policy, sent %d", len(q.sent))
+	}
+	if record, _ := svc.GetTransaction(ctx, txHash); record.Status != TxPending {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	_, err = svc.CancelTransaction(ctx, txHash)
+	if !errors.Is(err, ErrPolicyViolation) || rpcError(err).Data.(PolicyViolation).Rule != "maxGasPrice" {
+		t.Fatalf("expected the cancellation to break maxGasPrice, got %v", err)
+	}
+
+	// A cancellation is sent to the account itself
+	db.putPolicy(accountPolicyScope(from), Policy{To: []string{to}})
+	_, err = svc.CancelTransaction(ctx, txHash)
+	if !errors.Is(err, ErrPolicyViolation) || rpcError(err).Data.(PolicyViolation).Rule != "to" {
+		t.Fatalf("expected the cancellation to break the recipient list, got %v", err)
+	}
+	db.putPolicy(accountPolicyScope(from), Policy{To: []string{to, from}})
+	if _, err := svc.CancelTransaction(ctx, txHash); err != nil {
+		t.Fatalf("cannot cancel transaction %s", err)
+	}
+}
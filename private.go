@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// Quorum private transactions carry only the hash of their payload. The
+// payload itself is stored with the transaction manager (Tessera), which
+// shares it with the privateFor parties, and the signed transaction is sent
+// with eth_sendRawPrivateTransaction.
+
+// txManager is a client for a Tessera third party API
+type txManager struct {
+	address string
+	client  *http.Client
+}
+
+func newTxManager(address string) *txManager {
+	return &txManager{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type storeRawRequest struct {
+	Payload string `json:"payload"`
+	From    string `json:"from,omitempty"`
+}
+
+type storeRawResponse struct {
+	Key string `json:"key"`
+}
+
+// storeRaw stores an unencrypted payload and returns the hash the transaction
+// should carry instead. An empty privateFrom uses the manager's default key.
+func (tm *txManager) storeRaw(ctx context.Context, payload []byte, privateFrom string) ([]byte, error) {
+	body, err := json.Marshal(storeRawRequest{
+		Payload: base64.StdEncoding.EncodeToString(payload),
+		From:    privateFrom,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", tm.address+"/storeraw", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := tm.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transaction manager returned %s", res.Status)
+	}
+
+	var stored storeRawResponse
+	if err := json.NewDecoder(res.Body).Decode(&stored); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(stored.Key)
+}
+
+// signedTransaction has the RLP layout of types.Transaction, so that the
+// signature values can be changed
+type signedTransaction struct {
+	AccountNonce uint64
+	Price        *big.Int
+	GasLimit     uint64
+	Recipient    *ethCommon.Address `rlp:"nil"`
+	Amount       *big.Int
+	Payload      []byte
+	V, R, S      *big.Int
+}
+
+// markPrivate changes the V of a homestead signature from 27/28 to 37/38,
+// which Quorum nodes read as a private transaction
+func markPrivate(tx *types.Transaction) (*types.Transaction, error) {
+	raw, err := ethRlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed signedTransaction
+	if err := ethRlp.DecodeBytes(raw, &signed); err != nil {
+		return nil, err
+	}
+
+	switch signed.V.Uint64() {
+	case 27, 28:
+		signed.V = new(big.Int).Add(signed.V, big.NewInt(10))
+	default:
+		return nil, fmt.Errorf("unexpected signature V %s", signed.V)
+	}
+
+	raw, err = ethRlp.EncodeToBytes(&signed)
+	if err != nil {
+		return nil, err
+	}
+
+	private := new(types.Transaction)
+	err = ethRlp.DecodeBytes(raw, private)
+	return private, err
+}
+
+// isPrivate reports whether a signed transaction is marked private
+func isPrivate(tx *types.Transaction) bool {
+	v, _, _ := tx.RawSignatureValues()
+	return v.Cmp(big.NewInt(37)) == 0 || v.Cmp(big.NewInt(38)) == 0
+}
+
+// sendPrivateTransaction submits a signed private transaction to the node
+func (svc transactionExecutorService) sendPrivateTransaction(ctx context.Context, tx *types.Transaction, privateFor []string) error {
+	raw, err := ethRlp.EncodeToBytes(tx)
+	if err != nil {
+		return err
+	}
+
+	params := []interface{}{hexutil.Encode(raw), map[string]interface{}{"privateFor": privateFor}}
+
+	u, _ := url.Parse(svc.quorumAddress)
+	client := jsonrpc.NewClient(u, "eth_sendRawPrivateTransaction", jsonrpc.ClientResponseDecoder(decodeRawRPCResponse))
+	_, err = client.Endpoint()(ctx, params)
+	return err
+}
+
+// ExecutePrivateTransaction stores the payload with the transaction manager,
+// then signs and sends a private transaction carrying its hash. Gas defaults
+// are as for ExecuteTransaction, estimated with the real payload.
+func (svc transactionExecutorService) ExecutePrivateTransaction(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, nonce *uint64, privateFrom string, privateFor []string) (string, error) {
+	if svc.txManager == nil {
+		return "", ErrPrivateDisabled
+	}
+
+	if _, err := svc.userAccount(ctx, from); err != nil {
+		return "", err
+	}
+
+	payload := ethCommon.FromHex(hexData)
+	gasLimit, gasPrice, defaults, err := svc.fillGas(ctx, from, to, amount, gasLimit, gasPrice, payload)
+	if err != nil {
+		return "", err
+	}
+	refundSpend, err := svc.checkPolicy(ctx, from, policyTx{to, amount, gasLimit, gasPrice, payload}, true)
+	if err != nil {
+		return "", err
+	}
+	refundQuota, err := svc.useTxQuota(ctx)
+	if err != nil {
+		refundSpend()
+		return "", err
+	}
+	refund := func() {
+		refundQuota()
+		refundSpend()
+	}
+
+	hash, err := svc.txManager.storeRaw(ctx, payload, privateFrom)
+	if err != nil {
+		refund()
+		log.Println("Error: storeraw")
+		log.Println(err)
+		return "", ErrTxManager
+	}
+
+	// Quorum only recognises homestead signatures as private
+	svc.chainID = nil
+	signed, replaces, err := svc.signTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexutil.Encode(hash), nonce, false)
+	if err != nil {
+		refund()
+		return "", err
+	}
+
+	tx, err := markPrivate(signed)
+	if err == nil {
+		err = svc.sendPrivateTransaction(ctx, tx, privateFor)
+	}
+	if err != nil {
+		refund()
+		if replaces == nil {
+			svc.nonces.Release(ethCommon.HexToAddress(from), signed.Nonce())
+		}
+		log.Println("Error: SendRawPrivateTransaction")
+		log.Println(err)
+		return "", nodeError(ErrQuorum, err)
+	}
+
+	if replaces != nil {
+		if _, err := svc.storeReplacement(ctx, replaces, tx); err != nil {
+			log.Println("Error: recording replacement", tx.Hash().Hex(), err)
+		}
+	} else {
+		svc.recordTransaction(ctx, tx, ethCommon.HexToAddress(from), TxPending, defaults)
+	}
+	svc.recordPrivacy(tx.Hash().Hex(), privateFrom, privateFor)
+
+	return tx.Hash().String(), nil
+}
+
+// recordPrivacy adds the private parties to a journal record
+func (svc transactionExecutorService) recordPrivacy(hash string, privateFrom string, privateFor []string) {
+	if svc.db == nil {
+		return
+	}
+
+	err := svc.db.updateTransaction(hash, func(r *TransactionRecord) error {
+		r.PrivateFrom = privateFrom
+		r.PrivateFor = privateFor
+		return nil
+	})
+	if err != nil {
+		log.Println("Error: recording private transaction", hash, err)
+	}
+}
+
+// ErrPrivateDisabled is returned for private transactions when no transaction manager is configured
+var ErrPrivateDisabled = newRPCError(ErrCodeMethodNotSupported, "private transactions are not enabled")
+
+// ErrTxManager is returned when the transaction manager cannot store a private payload
+var ErrTxManager = newRPCError(ErrCodeServer, "error using transaction manager")
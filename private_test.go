@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+var (
+	testPrivateFrom = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	testPrivateFor  = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	testPayloadHash = bytes.Repeat([]byte{0xab}, 64)
+)
+
+// newFakeTxManager stores payloads sent to /storeraw and returns a fixed hash
+func newFakeTxManager(t *testing.T, stored *[]storeRawRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/storeraw" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req storeRawRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("cannot decode storeraw request %s", err)
+			return
+		}
+		*stored = append(*stored, req)
+		json.NewEncoder(w).Encode(storeRawResponse{Key: base64.StdEncoding.EncodeToString(testPayloadHash)})
+	}))
+}
+
+// newFakePrivateNode records the params of eth_sendRawPrivateTransaction
+func newFakePrivateNode(t *testing.T, sent *[]json.RawMessage) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_sendRawPrivateTransaction" {
+			t.Errorf("unexpected node request %s %v", req.Method, err)
+			return
+		}
+		*sent = append(*sent, req.Params)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "0x01"})
+	}))
+}
+
+func TestPrivateTransaction(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	var stored []storeRawRequest
+	tm := newFakeTxManager(t, &stored)
+	defer tm.Close()
+	var sent []json.RawMessage
+	node := newFakePrivateNode(t, &sent)
+	defer node.Close()
+
+	svc, q := NewTestService()
+	svc.db = db
+	svc.quorumAddress = node.URL
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	request := `{"jsonrpc":"2.0","id":1,"method":"eth_sendTransaction","params":[{"from":"` + from + `","to":"0x0000000000000000000000000000000000000001","gas":"0x5208","gasPrice":"0x0","data":"0x6060","privateFrom":"` + testPrivateFrom + `","privateFor":["` + testPrivateFor + `"]}]}`
+
+	var res testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, request), &res)
+	if res.Error == nil || res.Error.Code != ErrCodeMethodNotSupported {
+		t.Fatalf("expected private transactions to be disabled, got %+v", res)
+	}
+
+	svc.txManager = newTxManager(tm.URL)
+	srv.Config.Handler = MakeRPCHandler(svc, 2)
+
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, request), &res)
+	if res.Error != nil {
+		t.Fatalf("cannot send private transaction %+v", res.Error)
+	}
+
+	if len(stored) != 1 || stored[0].Payload != base64.StdEncoding.EncodeToString([]byte{0x60, 0x60}) || stored[0].From != testPrivateFrom {
+		t.Fatalf("unexpected stored payloads %+v", stored)
+	}
+	if len(q.sent) != 0 || len(sent) != 1 {
+		t.Fatalf("expected one private and no public transactions, got %d and %d", len(sent), len(q.sent))
+	}
+
+	var params []json.RawMessage
+	var raw hexutil.Bytes
+	var args struct {
+		PrivateFor []string `json:"privateFor"`
+	}
+	json.Unmarshal(sent[0], &params)
+	if len(params) != 2 || json.Unmarshal(params[0], &raw) != nil || json.Unmarshal(params[1], &args) != nil {
+		t.Fatalf("unexpected node params %s", sent[0])
+	}
+	if len(args.PrivateFor) != 1 || args.PrivateFor[0] != testPrivateFor {
+		t.Fatalf("unexpected privateFor %+v", args)
+	}
+
+	tx := new(types.Transaction)
+	if err := ethRlp.DecodeBytes(raw, tx); err != nil {
+		t.Fatalf("cannot decode private transaction %s", err)
+	}
+	if !isPrivate(tx) || !bytes.Equal(tx.Data(), testPayloadHash) {
+		t.Fatalf("expected private transaction carrying the payload hash, got data %x", tx.Data())
+	}
+
+	var txHash string
+	json.Unmarshal(res.Result, &txHash)
+	if txHash != tx.Hash().Hex() {
+		t.Fatalf("expected hash %s, got %s", tx.Hash().Hex(), txHash)
+	}
+
+	record, err := db.getTransaction(txHash)
+	if err != nil || record == nil || record.PrivateFrom != testPrivateFrom || len(record.PrivateFor) != 1 {
+		t.Fatalf("expected private parties in journal, got %+v %v", record, err)
+	}
+
+	// Private transactions cannot be signed without being sent
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":2,"method":"eth_signTransaction","params":[{"from":"`+from+`","data":"0x6060","privateFor":["`+testPrivateFor+`"]}]}`), &res)
+	if res.Error == nil || res.Error.Code != -32602 {
+		t.Fatalf("expected invalid params, got %+v", res)
+	}
+}
+
+func TestMarkPrivate(t *testing.T) {
+	svc, _ := NewTestService()
+	from, err := svc.GenerateKey(context.Background())
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	account, _ := svc.account(from)
+	tx, err := svc.signer.SignTx(account, types.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(0), testPayloadHash), nil)
+	if err != nil {
+		t.Fatalf("cannot sign %s", err)
+	}
+
+	private, err := markPrivate(tx)
+	if err != nil {
+		t.Fatalf("cannot mark private %s", err)
+	}
+
+	v, r, s := tx.RawSignatureValues()
+	pv, pr, ps := private.RawSignatureValues()
+	if isPrivate(tx) || !isPrivate(private) || pv.Int64() != v.Int64()+10 || pr.Cmp(r) != 0 || ps.Cmp(s) != 0 {
+		t.Fatalf("unexpected signature %s %s %s", pv, pr, ps)
+	}
+	if private.To() != nil || private.Nonce() != tx.Nonce() || !bytes.Equal(private.Data(), tx.Data()) {
+		t.Fatal("expected the rest of the transaction unchanged")
+	}
+}
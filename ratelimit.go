@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter holds a token bucket for each user and class of method
+type rateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+// userRateLimiter is shared by the HTTP and websocket handlers
+var userRateLimiter = newRateLimiter()
+
+// allow takes a token from the bucket of a user's class of methods, which
+// refills at limit per second and holds a second's worth. A limit of zero
+// allows everything.
+func (l *rateLimiter) allow(email string, class string, limit float64) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	key := email + "/" + class
+
+	l.mu.Lock()
+	limiter, ok := l.limiters[key]
+	// The limit may have been changed with the user command
+	if !ok || float64(limiter.Limit()) != limit {
+		burst := int(math.Ceil(limit))
+		limiter = rate.NewLimiter(rate.Limit(limit), burst)
+		l.limiters[key] = limiter
+	}
+	l.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// limitMethod applies the user's rate limit for a method's class. Methods
+// that administer count as signing.
+func limitMethod(user User, method string) error {
+	class, limit := "read", user.ReadRate
+	if methodClass(method) != methodClassRead {
+		class, limit = "sign", user.SignRate
+	}
+
+	if !userRateLimiter.allow(user.Email, class, limit) {
+		return ErrRateLimited.derive(fmt.Sprintf("%s: %g %s requests per second", ErrRateLimited.Message, limit, class), nil)
+	}
+
+	return nil
+}
+
+// useTxQuota counts a transaction against the authenticated user's daily
+// quota, failing if it has been used up. The returned function gives the
+// transaction back if it is not signed or sent after all.
+func (svc transactionExecutorService) useTxQuota(ctx context.Context) (func(), error) {
+	user, ok := ctx.Value(permissionsContextKey).(User)
+	if !ok || user.DailyTxQuota == 0 || svc.db == nil {
+		return func() {}, nil
+	}
+
+	now := time.Now().UTC()
+	allowed, err := svc.db.useQuota(user.Email, user.DailyTxQuota, now)
+	if err != nil {
+		log.Println("Error: useQuota")
+		log.Println(err)
+		return nil, ErrDatabase
+	}
+	if !allowed {
+		return nil, ErrTxQuotaExceeded.derive(fmt.Sprintf("%s: %d transactions per day", ErrTxQuotaExceeded.Message, user.DailyTxQuota), nil)
+	}
+
+	return func() {
+		if err := svc.db.refundQuota(user.Email, now); err != nil {
+			log.Println("Error: refundQuota", user.Email, err)
+		}
+	}, nil
+}
+
+// formatLimits describes a user's limits for the user command
+func formatLimits(user User) string {
+	limits := []string{}
+	if user.ReadRate > 0 {
+		limits = append(limits, fmt.Sprintf("read=%g/s", user.ReadRate))
+	}
+	if user.SignRate > 0 {
+		limits = append(limits, fmt.Sprintf("sign=%g/s", user.SignRate))
+	}
+	if user.DailyTxQuota > 0 {
+		limits = append(limits, fmt.Sprintf("tx=%d/day", user.DailyTxQuota))
+	}
+
+	if len(limits) == 0 {
+		return "-"
+	}
+
+	return strings.Join(limits, ",")
+}
+
+// ErrRateLimited is returned when a user calls methods faster than their rate limit
+var ErrRateLimited = newRPCError(ErrCodeLimitExceeded, "rate limit exceeded")
+
+// ErrTxQuotaExceeded is returned when a user has sent their daily quota of transactions
+var ErrTxQuotaExceeded = newRPCError(ErrCodeLimitExceeded, "daily transaction quota exceeded")
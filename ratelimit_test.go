@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+
+	for i := 0; i < 2; i++ {
+		if !l.allow("limited@example.com", "sign", 2) {
+			t.Fatalf("expected request %d within the burst", i)
+		}
+	}
+	if l.allow("limited@example.com", "sign", 2) {
+		t.Fatal("expected the bucket to be empty")
+	}
+	if !l.allow("limited@example.com", "read", 2) || !l.allow("other@example.com", "sign", 2) {
+		t.Fatal("expected separate buckets per user and class")
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	if !l.allow("limited@example.com", "sign", 2) {
+		t.Fatal("expected the bucket to refill")
+	}
+
+	for i := 0; i < 10; i++ {
+		if !l.allow("limited@example.com", "sign", 0) {
+			t.Fatal("expected no limit")
+		}
+	}
+}
+
+func TestRateLimitHTTP(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	db.deleteUser("ratelimit@example.com")
+	token, err := db.createUser("ratelimit@example.com", RoleSigner, nil)
+	if err != nil {
+		t.Fatalf("cannot create user %s", err)
+	}
+	defer db.deleteUser("ratelimit@example.com")
+
+	var out bytes.Buffer
+	runUserCommand(db, &out, []string{"-email", "ratelimit@example.com", "-sign-rate", "1"})
+	if out.String() != "ratelimit@example.com sign=1/s\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	svc, _ := NewTestService()
+	h := Auth(db, MakeRPCHandler(svc, 2))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", token)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	post := func(method string) *http.Response {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":["0x0000000000000000000000000000000000000001","0x12"]}`
+		resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("cannot post %s", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post("eth_sign"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the first request to be served, got %s", resp.Status)
+	}
+	if resp := post("eth_sign"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %s", resp.Status)
+	}
+	// Reads are limited separately
+	if resp := post("personal_ecRecover"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected a read to be served, got %s", resp.Status)
+	}
+}
+
+func TestTxQuota(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.quotaBucket).Delete([]byte("quota@example.com"))
+	})
+
+	svc, q := NewTestService()
+	svc.db = db
+	address, err := svc.PersonalNewAccount(context.Background(), "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	user := User{Email: "quota@example.com", DailyTxQuota: 1}
+	ctx := context.WithValue(context.Background(), permissionsContextKey, user)
+	to := "0x0000000000000000000000000000000000000001"
+
+	// A transaction the node rejects does not use up the quota
+	q.sendErr = errors.New("insufficient funds")
+	if _, err := svc.ExecuteTransaction(ctx, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+	q.sendErr = nil
+
+	if _, err := svc.ExecuteTransaction(ctx, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil); err != nil {
+		t.Fatalf("expected a transaction within the quota, got %s", err)
+	}
+	_, err = svc.ExecuteTransaction(ctx, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if !errors.Is(err, ErrTxQuotaExceeded) || !strings.Contains(err.Error(), "1 transactions per day") {
+		t.Fatalf("expected %s, got %v", ErrTxQuotaExceeded, err)
+	}
+
+	// The quota starts again the next day
+	allowed, err := db.useQuota("quota@example.com", 1, time.Now().UTC().Add(24*time.Hour))
+	if err != nil || !allowed {
+		t.Fatalf("expected the quota to reset, got %v %v", allowed, err)
+	}
+}
+
+func TestSignThenSendCharge(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.quotaBucket).Delete([]byte("signsend@example.com"))
+	})
+
+	node := newFakeNode(t)
+	defer node.Close()
+
+	svc, _ := NewTestService()
+	svc.db = db
+	svc.quorumAddress = node.URL
+	address, err := svc.PersonalNewAccount(context.Background(), "")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+	if err := db.putPolicy(accountPolicyScope(address), Policy{MaxDailyValue: big.NewInt(1)}); err != nil {
+		t.Fatalf("cannot set policy %s", err)
+	}
+	defer db.deletePolicy(accountPolicyScope(address))
+
+	ctx := context.WithValue(context.Background(), permissionsContextKey, User{Email: "signsend@example.com", DailyTxQuota: 1})
+	to := "0x0000000000000000000000000000000000000001"
+
+	// Signing charges nothing, so both transactions can be signed
+	var raw []interface{}
+	for i := 0; i < 2; i++ {
+		signed, err := svc.EthSignTransaction(ctx, address, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+		if err != nil {
+			t.Fatalf("cannot sign transaction %s", err)
+		}
+		raw = append(raw, signed)
+	}
+
+	// Sending charges the quota and the daily value once
+	if _, err := svc.EthSendRawTransaction(ctx, []interface{}{raw[0]}); err != nil {
+		t.Fatalf("cannot send signed transaction %s", err)
+	}
+	if _, err := svc.EthSendRawTransaction(ctx, []interface{}{raw[1]}); !errors.Is(err, ErrPolicyViolation) || rpcError(err).Data.(PolicyViolation).Rule != "maxDailyValue" {
+		t.Fatalf("expected the daily value to be used up, got %v", err)
+	}
+
+	db.putPolicy(accountPolicyScope(address), Policy{})
+	if _, err := svc.EthSendRawTransaction(ctx, []interface{}{raw[1]}); !errors.Is(err, ErrTxQuotaExceeded) {
+		t.Fatalf("expected %s, got %v", ErrTxQuotaExceeded, err)
+	}
+}
+
+func TestUserCommandBadFlag(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+
+	// Over IPC this runs in the server, which must keep running
+	var out bytes.Buffer
+	runUserCommand(db, &out, []string{"-email", "badflag@example.com", "-tx-quota", "many"})
+	if !strings.Contains(out.String(), "invalid value \"many\" for flag -tx-quota") {
+		t.Fatalf("expected the parse error in the output, got %q", out.String())
+	}
+}
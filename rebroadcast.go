@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	ethereum "github.com/eximchain/go-ethereum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+)
+
+const (
+	// The minimum price increase the txpool accepts for a replacement
+	defaultGasBumpPercent = 10
+	cancelGasLimit        = 21000
+)
+
+// rebroadcastConfig controls how pending transactions are resent
+type rebroadcastConfig struct {
+	interval time.Duration
+	// Replace a transaction with a higher gas price once it has been pending
+	// this long; zero disables replacement
+	bumpAfter   time.Duration
+	bumpPercent int64
+	// Maximum number of rebroadcasts and replacements per nonce; zero is unlimited
+	maxAttempts int
+}
+
+// RebroadcastTransactions periodically resends pending journal transactions
+// the node no longer knows about, until the context is cancelled.
+func (svc transactionExecutorService) RebroadcastTransactions(ctx context.Context, cfg rebroadcastConfig) {
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			svc.rebroadcastPending(ctx, cfg)
+		}
+	}
+}
+
+func (svc transactionExecutorService) rebroadcastPending(ctx context.Context, cfg rebroadcastConfig) {
+	records, err := svc.db.openTransactions(TransactionFilter{Status: TxPending})
+	if err != nil {
+		log.Println("Error: listing transactions", err)
+		return
+	}
+
+	for i := range records {
+		record := &records[i]
+		if cfg.maxAttempts > 0 && record.Attempts >= cfg.maxAttempts {
+			continue
+		}
+
+		tx, err := record.transaction()
+		if err != nil {
+			log.Println("Error: decoding transaction", record.Hash, err)
+			continue
+		}
+
+		// Quorum networks run with a zero gas price, which cannot be bumped
+		if cfg.bumpAfter > 0 && time.Since(record.Created) >= cfg.bumpAfter && tx.GasPrice().Sign() > 0 {
+			gasPrice := bumpGasPrice(tx.GasPrice(), cfg.bumpPercent)
+
+			// Judge the bump by the policies of the user who sent it; one that
+			// breaks them leaves the transaction as it is
+			userCtx := context.WithValue(ctx, userContextKey, record.User)
+			if _, err := svc.checkPolicy(userCtx, record.From, replacementPolicyTx(tx, gasPrice), false); err != nil {
+				log.Println("Not replacing stuck transaction", record.Hash, err)
+				svc.rebroadcast(ctx, record, tx)
+				continue
+			}
+
+			replacement, err := svc.replaceTransaction(ctx, record, tx.To(), tx.Value(), tx.Gas(), gasPrice, tx.Data())
+			if err != nil {
+				log.Println("Error: replacing transaction", record.Hash, err)
+				continue
+			}
+			log.Printf("Replaced stuck transaction %s with %s at gas price %s", record.Hash, replacement.Hash, gasPrice)
+			continue
+		}
+
+		svc.rebroadcast(ctx, record, tx)
+	}
+}
+
+// rebroadcast resends a transaction if the node has lost it
+func (svc transactionExecutorService) rebroadcast(ctx context.Context, record *TransactionRecord, tx *types.Transaction) {
+	_, _, err := svc.quorumClient.TransactionByHash(ctx, tx.Hash())
+	if err == nil {
+		return
+	}
+	if err != ethereum.NotFound {
+		log.Println("Error: TransactionByHash", record.Hash, err)
+		return
+	}
+
+	status := TxPending
+	if len(record.PrivateFor) > 0 {
+		err = svc.sendPrivateTransaction(ctx, tx, record.PrivateFor)
+	} else {
+		err = svc.quorumClient.SendRawTransaction(ctx, tx)
+	}
+	switch {
+	case err == nil:
+		log.Println("Rebroadcast transaction", record.Hash)
+	case isNonceTooLow(err):
+		log.Println("Dropping transaction whose nonce was used", record.Hash)
+		status = TxDropped
+	case strings.Contains(err.Error(), "known transaction"):
+	default:
+		log.Println("Error: SendRawTransaction", record.Hash, err)
+	}
+
+	err = svc.db.updateTransaction(record.Hash, func(r *TransactionRecord) error {
+		if r.Status != TxPending {
+			return errStaleRecord
+		}
+		r.Status = status
+		r.Attempts++
+		r.Updated = time.Now().UTC()
+		return nil
+	})
+	if err != nil && err != errStaleRecord {
+		log.Println("Error: updating transaction", record.Hash, err)
+	}
+}
+
+// replaceTransaction signs and sends a new transaction with the record's
+// nonce, then marks the record as replaced by it. Replacements of private
+// transactions that keep the payload hash are private too.
+func (svc transactionExecutorService) replaceTransaction(ctx context.Context, record *TransactionRecord, to *ethCommon.Address, value *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) (*TransactionRecord, error) {
+	account, err := svc.account(record.From)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *types.Transaction
+	if to == nil {
+		tx = types.NewContractCreation(uint64(record.Nonce), value, gasLimit, gasPrice, data)
+	} else {
+		tx = types.NewTransaction(uint64(record.Nonce), *to, value, gasLimit, gasPrice, data)
+	}
+
+	private := len(record.PrivateFor) > 0 && len(data) > 0
+	chainID := svc.chainID
+	if private {
+		chainID = nil
+	}
+
+	tx, err = svc.signer.SignTx(account, tx, chainID)
+	if err == nil && private {
+		tx, err = markPrivate(tx)
+	}
+	if err != nil {
+		return nil, signerError(err)
+	}
+
+	if isPrivate(tx) {
+		err = svc.sendPrivateTransaction(ctx, tx, record.PrivateFor)
+	} else {
+		err = svc.quorumClient.SendTransaction(ctx, tx)
+	}
+	if err != nil {
+		log.Println("Error: SendTransaction")
+		log.Println(err)
+		return nil, nodeError(ErrQuorum, err)
+	}
+
+	return svc.storeReplacement(ctx, record, tx)
+}
+
+// storeReplacement journals a sent transaction that reuses the nonce of
+// record, and marks record as replaced by it
+func (svc transactionExecutorService) storeReplacement(ctx context.Context, record *TransactionRecord, tx *types.Transaction) (*TransactionRecord, error) {
+	replacement, err := newTransactionRecord(ctx, tx, ethCommon.HexToAddress(record.From), TxPending)
+	if err != nil {
+		return nil, err
+	}
+	if replacement.User == "" {
+		replacement.User = record.User
+	}
+	replacement.Replaces = record.Hash
+	replacement.Attempts = record.Attempts + 1
+	if isPrivate(tx) {
+		replacement.PrivateFrom = record.PrivateFrom
+		replacement.PrivateFor = record.PrivateFor
+	}
+
+	if err := svc.db.putTransaction(replacement); err != nil {
+		return nil, err
+	}
+
+	err = svc.db.updateTransaction(record.Hash, func(r *TransactionRecord) error {
+		r.Status = TxReplaced
+		r.ReplacedBy = replacement.Hash
+		r.Updated = time.Now().UTC()
+		return nil
+	})
+
+	return replacement, err
+}
+
+// CancelTransaction replaces a pending transaction with a zero value transfer
+// to its sender at a higher gas price, returning the replacement hash
+func (svc transactionExecutorService) CancelTransaction(ctx context.Context, hash string) (string, error) {
+	record, tx, err := svc.pendingTransaction(hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := svc.userAccount(ctx, record.From); err != nil {
+		return "", err
+	}
+
+	from := ethCommon.HexToAddress(record.From)
+	gasPrice := bumpGasPrice(tx.GasPrice(), defaultGasBumpPercent)
+	if _, err := svc.checkPolicy(ctx, record.From, policyTx{record.From, big.NewInt(0), cancelGasLimit, gasPrice, nil}, false); err != nil {
+		return "", err
+	}
+	replacement, err := svc.replaceTransaction(ctx, record, &from, big.NewInt(0), cancelGasLimit, gasPrice, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return replacement.Hash, nil
+}
+
+// SpeedUpTransaction re-signs a pending transaction with a higher gas price,
+// returning the replacement hash. A nil gas price bumps the current one.
+func (svc transactionExecutorService) SpeedUpTransaction(ctx context.Context, hash string, gasPrice *big.Int) (string, error) {
+	record, tx, err := svc.pendingTransaction(hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := svc.userAccount(ctx, record.From); err != nil {
+		return "", err
+	}
+
+	price := gasPrice
+	if price == nil {
+		price = bumpGasPrice(tx.GasPrice(), defaultGasBumpPercent)
+	}
+	if price.Cmp(tx.GasPrice()) <= 0 {
+		return "", ErrGasPriceTooLow
+	}
+
+	if _, err := svc.checkPolicy(ctx, record.From, replacementPolicyTx(tx, price), false); err != nil {
+		return "", err
+	}
+
+	replacement, err := svc.replaceTransaction(ctx, record, tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+	if err != nil {
+		return "", err
+	}
+
+	return replacement.Hash, nil
+}
+
+// replacementPolicyTx describes tx at a higher gas price to checkPolicy. The
+// data is not judged again and the value was counted when the transaction
+// was first signed.
+func replacementPolicyTx(tx *types.Transaction, gasPrice *big.Int) policyTx {
+	to := ""
+	if tx.To() != nil {
+		to = tx.To().Hex()
+	}
+	return policyTx{to, tx.Value(), tx.Gas(), gasPrice, nil}
+}
+
+// pendingTransaction loads a journal record that can still be replaced
+func (svc transactionExecutorService) pendingTransaction(hash string) (*TransactionRecord, *types.Transaction, error) {
+	if svc.db == nil {
+		return nil, nil, ErrJournalDisabled
+	}
+
+	record, err := svc.db.getTransaction(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		return nil, nil, ErrTransactionMissing
+	}
+	if record.Status != TxPending {
+		return nil, nil, ErrTransactionNotPending
+	}
+
+	tx, err := record.transaction()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return record, tx, nil
+}
+
+// bumpGasPrice raises a gas price by the given percentage, by at least one wei
+func bumpGasPrice(gasPrice *big.Int, percent int64) *big.Int {
+	bumped := new(big.Int).Mul(gasPrice, big.NewInt(100+percent))
+	bumped.Div(bumped, big.NewInt(100))
+
+	if bumped.Cmp(gasPrice) <= 0 {
+		bumped.Add(gasPrice, big.NewInt(1))
+	}
+
+	return bumped
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+)
+
+func TestBumpGasPrice(t *testing.T) {
+	if p := bumpGasPrice(big.NewInt(100), 10); p.Int64() != 110 {
+		t.Fatalf("expected 110, got %s", p)
+	}
+
+	if p := bumpGasPrice(big.NewInt(5), 10); p.Int64() != 6 {
+		t.Fatalf("expected 6, got %s", p)
+	}
+}
+
+func TestRebroadcast(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	to := "0x0000000000000000000000000000000000000001"
+	txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	cfg := rebroadcastConfig{maxAttempts: 1}
+
+	// Still in the pool; nothing to do
+	svc.rebroadcastPending(ctx, cfg)
+	if len(q.sent) != 1 {
+		t.Fatalf("unexpected rebroadcast, sent %d", len(q.sent))
+	}
+
+	q.evict(q.sent[0])
+	svc.rebroadcastPending(ctx, cfg)
+	if len(q.sent) != 2 || q.sent[1].Hash().Hex() != txHash {
+		t.Fatalf("transaction not rebroadcast, sent %d", len(q.sent))
+	}
+
+	// Attempts are limited
+	q.evict(q.sent[0])
+	svc.rebroadcastPending(ctx, cfg)
+	if len(q.sent) != 2 {
+		t.Fatalf("rebroadcast beyond limit, sent %d", len(q.sent))
+	}
+
+	record, _ := svc.GetTransaction(ctx, txHash)
+	if record.Attempts != 1 || record.Status != TxPending {
+		t.Fatalf("unexpected record %+v", record)
+	}
+}
+
+func TestGasBump(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, "0x0000000000000000000000000000000000000001", big.NewInt(1), 21000, big.NewInt(100), "0x01", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	svc.rebroadcastPending(ctx, rebroadcastConfig{bumpAfter: time.Nanosecond, bumpPercent: 20})
+	if len(q.sent) != 2 {
+		t.Fatalf("transaction not replaced, sent %d", len(q.sent))
+	}
+
+	replacement := q.sent[1]
+	if replacement.Nonce() != q.sent[0].Nonce() || replacement.GasPrice().Int64() != 120 || string(replacement.Data()) != "\x01" {
+		t.Fatalf("unexpected replacement nonce %d gas price %s", replacement.Nonce(), replacement.GasPrice())
+	}
+
+	record, _ := svc.GetTransaction(ctx, txHash)
+	if record.Status != TxReplaced || record.ReplacedBy != replacement.Hash().Hex() {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	record, _ = svc.GetTransaction(ctx, replacement.Hash().Hex())
+	if record.Status != TxPending || record.Replaces != txHash || record.Attempts != 1 {
+		t.Fatalf("unexpected replacement record %+v", record)
+	}
+}
+
+func TestCancelAndSpeedUpTransaction(t *testing.T) {
+	db := NewTestDB()
+	defer db.close()
+	ClearTestJournal(t, db)
+
+	svc, q := NewTestService()
+	svc.db = db
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	txHash, err := svc.ExecuteTransaction(ctx, from, "0x0000000000000000000000000000000000000001", big.NewInt(5), 50000, big.NewInt(100), "0x01", nil)
+	if err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	if _, err := svc.SpeedUpTransaction(ctx, txHash, big.NewInt(100)); err != ErrGasPriceTooLow {
+		t.Fatalf("expected %s, got %v", ErrGasPriceTooLow, err)
+	}
+
+	fastHash, err := svc.SpeedUpTransaction(ctx, txHash, big.NewInt(200))
+	if err != nil {
+		t.Fatalf("cannot speed up transaction %s", err)
+	}
+
+	fast := q.sent[len(q.sent)-1]
+	if fast.Hash().Hex() != fastHash || fast.GasPrice().Int64() != 200 || fast.Value().Int64() != 5 || fast.Gas() != 50000 {
+		t.Fatalf("unexpected replacement %s", fastHash)
+	}
+
+	if _, err := svc.CancelTransaction(ctx, txHash); err != ErrTransactionNotPending {
+		t.Fatalf("expected %s, got %v", ErrTransactionNotPending, err)
+	}
+
+	cancelHash, err := svc.CancelTransaction(ctx, fastHash)
+	if err != nil {
+		t.Fatalf("cannot cancel transaction %s", err)
+	}
+
+	cancel := q.sent[len(q.sent)-1]
+	if cancel.Hash().Hex() != cancelHash || cancel.Nonce() != fast.Nonce() || cancel.Value().Sign() != 0 ||
+		cancel.To().Hex() != ethCommon.HexToAddress(from).Hex() || cancel.GasPrice().Int64() != 220 {
+		t.Fatalf("unexpected cancellation %s", cancelHash)
+	}
+
+	if _, err := svc.CancelTransaction(ctx, "0x1234"); err != ErrTransactionMissing {
+		t.Fatalf("expected %s, got %v", ErrTransactionMissing, err)
+	}
+}
@@ -1,24 +1,87 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+
 	"github.com/go-kit/kit/transport/http/jsonrpc"
 )
 
-func MakeRPCHandler(svc transactionExecutorService) *jsonrpc.Server {
+// MakeRPCHandler serves the executor's JSON-RPC methods. Batches are served
+// running up to batchConcurrency read-only calls at a time.
+func MakeRPCHandler(svc transactionExecutorService, batchConcurrency int) http.Handler {
+	m := makeEndpointCodecMap(svc)
+
+	return makeBatchHandler(svc, m, batchConcurrency)
+}
+
+func makeBatchHandler(svc transactionExecutorService, m jsonrpc.EndpointCodecMap, batchConcurrency int) *batchHandler {
+	h := newBatchHandler(m, jsonrpc.NewServer(m, jsonrpc.ServerErrorEncoder(encodeRPCError)), batchConcurrency)
+	if svc.passthroughMethods != nil {
+		passthrough := makePassthroughEndpoint(svc)
+		h.fallback = func(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+			if err := authorizeMethod(ctx, method); err != nil {
+				return nil, err
+			}
+			return passthrough(ctx, method, params)
+		}
+	}
+
+	return h
+}
+
+func makeEndpointCodecMap(svc transactionExecutorService) jsonrpc.EndpointCodecMap {
 	m := make(jsonrpc.EndpointCodecMap)
 
 	m["eth_sendTransaction"] = jsonrpc.EndpointCodec{
 		Endpoint: makeEthSendTransactionEndpoint(svc),
-		Decode:   decodeRPCTransactionRequest,
+		Decode:   makeRPCTransactionDecoder(svc.signer),
 		Encode:   encodeRPCResponse,
 	}
 
 	m["personal_newAccount"] = jsonrpc.EndpointCodec{
 		Endpoint: makePersonalNewAccountEndpoint(svc),
+		Decode:   makeStringParamsDecoder(0, "passphrase"),
+		Encode:   encodeRPCResponse,
+	}
+
+	m["personal_listAccounts"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalListAccountsEndpoint(svc),
 		Decode:   decodeRPCRequest,
 		Encode:   encodeRPCResponse,
 	}
 
+	m["personal_sign"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalSignEndpoint(svc),
+		Decode:   makeStringParamsDecoder(2, "data", "address", "passphrase"),
+		Encode:   encodeRPCResponse,
+	}
+
+	m["personal_ecRecover"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalEcRecoverEndpoint(svc),
+		Decode:   makeStringParamsDecoder(2, "data", "signature"),
+		Encode:   encodeRPCResponse,
+	}
+
+	m["personal_importRawKey"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalImportRawKeyEndpoint(svc),
+		Decode:   makeStringParamsDecoder(1, "key", "passphrase"),
+		Encode:   encodeRPCResponse,
+	}
+
+	m["personal_unlockAccount"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalUnlockAccountEndpoint(svc),
+		Decode:   decodeUnlockAccountRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	m["personal_lockAccount"] = jsonrpc.EndpointCodec{
+		Endpoint: makePersonalLockAccountEndpoint(svc),
+		Decode:   makeStringParamsDecoder(1, "address"),
+		Encode:   encodeRPCResponse,
+	}
+
 	m["web3_clientVersion"] = jsonrpc.EndpointCodec{
 		Endpoint: makeWeb3ClientVersionEndpoint(svc),
 		Decode:   decodeRPCRequest,
@@ -151,9 +214,21 @@ func MakeRPCHandler(svc transactionExecutorService) *jsonrpc.Server {
 		Encode:   encodeRPCResponse,
 	}
 
+	m["eth_signTypedData_v3"] = jsonrpc.EndpointCodec{
+		Endpoint: makeEthSignTypedDataEndpoint(svc, "eth_signTypedData_v3", false),
+		Decode:   decodeTypedDataRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	m["eth_signTypedData_v4"] = jsonrpc.EndpointCodec{
+		Endpoint: makeEthSignTypedDataEndpoint(svc, "eth_signTypedData_v4", true),
+		Decode:   decodeTypedDataRequest,
+		Encode:   encodeRPCResponse,
+	}
+
 	m["eth_signTransaction"] = jsonrpc.EndpointCodec{
 		Endpoint: makeEthSignTransactionEndpoint(svc),
-		Decode:   decodeRPCTransactionRequest,
+		Decode:   makeRPCTransactionDecoder(svc.signer),
 		Encode:   encodeRPCResponse,
 	}
 
@@ -283,7 +358,34 @@ func MakeRPCHandler(svc transactionExecutorService) *jsonrpc.Server {
 		Encode:   encodeRPCResponse,
 	}
 
-	handler := jsonrpc.NewServer(m)
+	m["executor_getTransaction"] = jsonrpc.EndpointCodec{
+		Endpoint: makeExecutorGetTransactionEndpoint(svc),
+		Decode:   decodeRPCRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	m["executor_listTransactions"] = jsonrpc.EndpointCodec{
+		Endpoint: makeExecutorListTransactionsEndpoint(svc),
+		Decode:   decodeTransactionFilterRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	m["executor_cancelTransaction"] = jsonrpc.EndpointCodec{
+		Endpoint: makeExecutorCancelTransactionEndpoint(svc),
+		Decode:   decodeRPCRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	m["executor_speedUpTransaction"] = jsonrpc.EndpointCodec{
+		Endpoint: makeExecutorSpeedUpTransactionEndpoint(svc),
+		Decode:   decodeRPCRequest,
+		Encode:   encodeRPCResponse,
+	}
+
+	for method, codec := range m {
+		codec.Endpoint = authorized(method, codec.Endpoint)
+		m[method] = codec
+	}
 
-	return handler
+	return m
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,7 +17,6 @@ import (
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/eximchain/eth-client/quorum"
-	"github.com/eximchain/go-ethereum/accounts"
 	"github.com/eximchain/go-ethereum/accounts/keystore"
 
 	vault "github.com/hashicorp/vault/api"
@@ -104,6 +104,30 @@ func RunServerCommand(args []string) {
 	authTokenFlag := serverCommand.String("auth-token", "", "An auth token to use instead of AWS authorization, for help with testing")
 	keyDirFlag := serverCommand.String("keystore", "/home/ubuntu/.ethereum/keystore", "The directory to use as a keystore")
 	disableAuthFlag := serverCommand.Bool("disable-auth", false, "Set to disable the authorization token check before serving requests")
+	signerFlag := serverCommand.String("signer", "keystore", "Where signing keys are stored: keystore, vault or memory")
+	vaultKeyPathFlag := serverCommand.String("vault-key-path", "keys", "The vault path under which account keys are stored")
+	confirmationsFlag := serverCommand.Uint64("confirmations", 6, "The number of blocks after which a mined transaction is confirmed")
+	untrackAfterFlag := serverCommand.Duration("untrack-after", 24*time.Hour, "Stop watching signed-only and replaced transactions that are unchanged this long; 0 watches them forever")
+	txPollIntervalFlag := serverCommand.Duration("tx-poll-interval", 5*time.Second, "How often to poll for transaction receipts when head subscriptions are unavailable")
+	rebroadcastIntervalFlag := serverCommand.Duration("rebroadcast-interval", time.Minute, "How often to resend pending transactions the node has lost; 0 disables rebroadcasting")
+	gasBumpAfterFlag := serverCommand.Duration("gas-bump-after", 0, "Replace transactions pending this long with a higher gas price; 0 disables replacement")
+	gasBumpPercentFlag := serverCommand.Int64("gas-bump-percent", 10, "The percentage by which to raise the gas price of a replacement transaction")
+	maxRebroadcastsFlag := serverCommand.Int("max-rebroadcasts", 10, "The maximum number of rebroadcasts and replacements per transaction; 0 is unlimited")
+	batchConcurrencyFlag := serverCommand.Int("batch-concurrency", defaultBatchConcurrency, "The maximum number of read-only calls from one batch request to run at once")
+	vaultPassphrasesFlag := serverCommand.Bool("vault-passphrases", false, "Set to keep keys in the keystore and store only their passphrases in vault")
+	passthroughFlag := serverCommand.Bool("passthrough", false, "Set to forward methods the executor does not handle to the quorum node")
+	passthroughAllowFlag := serverCommand.String("passthrough-allow", "", "Comma-separated method names or glob patterns to forward, e.g. eth_*,txpool_content; nothing is forwarded without it")
+	passthroughDenyFlag := serverCommand.String("passthrough-deny", "", "Comma-separated method names or glob patterns never to forward, in addition to signing and node management methods")
+	gasMultiplierFlag := serverCommand.Float64("gas-multiplier", defaultGasMultiplier, "The factor applied to the node's gas estimate when a transaction has no gas limit")
+	gasCapFlag := serverCommand.Uint64("gas-cap", 0, "The maximum gas limit the executor will estimate; 0 is unlimited")
+	gasPriceFlag := serverCommand.String("gas-price", "", "A fixed gas price in wei for transactions without one, e.g. 0 on Quorum; by default the node suggests one")
+	wsOriginsFlag := serverCommand.String("ws-origins", "", "Comma-separated origins allowed to open websocket connections, or * for any; empty allows only the executor's own host")
+	wsMaxSubscriptionsFlag := serverCommand.Int("ws-max-subscriptions", defaultMaxSubscriptions, "The maximum number of subscriptions per websocket connection; 0 is unlimited")
+	quorumWSAddressFlag := serverCommand.String("quorum-ws-address", "", "A websocket address of the quorum node to use for subscriptions, e.g. ws://127.0.0.1:8546")
+	txManagerAddressFlag := serverCommand.String("tx-manager-address", "", "The Tessera third party API address for private transactions, e.g. http://127.0.0.1:9080; empty disables them")
+	chainIDFlag := serverCommand.String("chain-id", "", "The chain ID for EIP-155 signatures, or auto to use the node's network ID; empty or 0 signs homestead transactions for Quorum")
+	accountsPerUserFlag := serverCommand.Bool("accounts-per-user", false, "Set to list only the accounts an authenticated user may use in eth_accounts and personal_listAccounts")
+	nodeAccountsFlag := serverCommand.Bool("node-accounts", false, "Set to include the quorum node's own accounts in eth_accounts")
 	serverCommand.Parse(args)
 
 	// Log Setup
@@ -139,18 +163,35 @@ func RunServerCommand(args []string) {
 		log.Fatal(err)
 	}
 
+	var quorumSubscriber quorum.Client
+	if *quorumWSAddressFlag != "" {
+		quorumSubscriber, err = quorum.Dial(*quorumWSAddressFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	gas := gasConfig{multiplier: *gasMultiplierFlag, cap: *gasCapFlag}
+	if *gasPriceFlag != "" {
+		price, ok := new(big.Int).SetString(*gasPriceFlag, 0)
+		if !ok || price.Sign() < 0 {
+			log.Fatalf("invalid gas price %q", *gasPriceFlag)
+		}
+		gas.fixedPrice = price
+	}
+
+	chainID, err := resolveChainID(context.Background(), *chainIDFlag, quorumClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if chainID != nil {
+		log.Printf("Signing with chain ID %s", chainID)
+	}
+
 	// Keystore setup
 	gethKeyDir := *keyDirFlag
 	gethKeystore := keystore.NewKeyStore(gethKeyDir, keystore.StandardScryptN, keystore.StandardScryptP)
 
-	svc := transactionExecutorService{
-		vaultClient:   vaultClient,
-		keystore:      gethKeystore,
-		quorumClient:  quorumClient,
-		quorumAddress: quorumAddress,
-		accountCache:  make(map[string]accounts.Account),
-	}
-
 	db := &BoltDB{}
 	err = db.open("eximchain.db")
 	if err != nil {
@@ -158,6 +199,47 @@ func RunServerCommand(args []string) {
 	}
 	defer db.close()
 
+	var signer Signer
+	switch *signerFlag {
+	case "keystore":
+		signer = newKeystoreSigner(gethKeystore)
+	case "vault":
+		if *vaultPassphrasesFlag {
+			signer = newVaultKeyStore(vaultClient, *vaultKeyPathFlag, gethKeystore)
+		} else {
+			signer = newVaultKeyStore(vaultClient, *vaultKeyPathFlag, nil)
+		}
+	case "memory":
+		log.Warn("Using in-memory signer; keys will be lost on shutdown")
+		signer = newMemorySigner()
+	default:
+		log.Fatalf("unknown signer %q", *signerFlag)
+	}
+
+	svc := transactionExecutorService{
+		vaultClient:   vaultClient,
+		signer:        signer,
+		nonces:        newNonceManager(quorumClient, db),
+		db:            db,
+		quorumClient:  quorumClient,
+		quorumAddress: quorumAddress,
+		subscriber:    quorumSubscriber,
+		gas:           gas,
+		chainID:       chainID,
+
+		accountsPerUser: *accountsPerUserFlag,
+		nodeAccounts:    *nodeAccountsFlag,
+	}
+	if *passthroughFlag {
+		if *passthroughAllowFlag == "" {
+			log.Warn("-passthrough is set without -passthrough-allow; no methods will be forwarded")
+		}
+		svc.passthroughMethods = newMethodFilter(*passthroughAllowFlag, *passthroughDenyFlag)
+	}
+	if *txManagerAddressFlag != "" {
+		svc.txManager = newTxManager(*txManagerAddressFlag)
+	}
+
 	// Listen on unix socket for user management commands
 	if listener := listenIPC(db); listener != nil {
 		defer func() {
@@ -166,14 +248,34 @@ func RunServerCommand(args []string) {
 			}
 		}()
 	}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go svc.WatchTransactions(watchCtx, *confirmationsFlag, *txPollIntervalFlag, *untrackAfterFlag)
+	if *rebroadcastIntervalFlag > 0 {
+		go svc.RebroadcastTransactions(watchCtx, rebroadcastConfig{
+			interval:    *rebroadcastIntervalFlag,
+			bumpAfter:   *gasBumpAfterFlag,
+			bumpPercent: *gasBumpPercentFlag,
+			maxAttempts: *maxRebroadcastsFlag,
+		})
+	}
+
+	wsCfg := wsConfig{
+		origins:          splitMethodList(*wsOriginsFlag),
+		maxSubscriptions: *wsMaxSubscriptionsFlag,
+	}
 	handler := new(http.Handler)
+	wsHandler := new(http.Handler)
 	if *disableAuthFlag {
-		*handler = DisableAuth(MakeRPCHandler(svc))
+		*handler = DisableAuth(MakeRPCHandler(svc, *batchConcurrencyFlag))
+		*wsHandler = DisableAuth(MakeWSHandler(svc, *batchConcurrencyFlag, wsCfg))
 	} else {
-		*handler = Auth(db, MakeRPCHandler(svc))
+		*handler = Auth(db, MakeRPCHandler(svc, *batchConcurrencyFlag))
+		*wsHandler = Auth(db, MakeWSHandler(svc, *batchConcurrencyFlag, wsCfg))
 	}
 
 	http.Handle("/", accessControl(*handler))
+	http.Handle("/ws", accessControl(*wsHandler))
 
 	stopChan := make(chan os.Signal, 1)
 
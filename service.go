@@ -2,17 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/big"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/eximchain/eth-client/quorum"
 	"github.com/eximchain/go-ethereum/accounts"
-	"github.com/eximchain/go-ethereum/accounts/keystore"
 	"github.com/eximchain/go-ethereum/core/types"
 	"github.com/eximchain/go-ethereum/crypto"
 	"github.com/go-kit/kit/transport/http/jsonrpc"
@@ -23,12 +22,25 @@ import (
 
 // Manages vault keys and executes transactions against an eximchain node
 type TransactionExecutorService interface {
-	ExecuteTransaction(context.Context, string, string, int64, uint64, int64, string) (string, error)
+	ExecuteTransaction(context.Context, string, string, *big.Int, uint64, *big.Int, string, *uint64) (string, error)
+	ExecutePrivateTransaction(context.Context, string, string, *big.Int, uint64, *big.Int, string, *uint64, string, []string) (string, error)
 	GetVaultKey(context.Context) (string, error)
 	GenerateKey(context.Context) (string, error)
-	GetBalance(context.Context, string) (int64, error)
-	RunWorkload(context.Context, string, string, int64, uint64, int64, string, int, int)
+	PersonalNewAccount(context.Context, string) (string, error)
+	PersonalListAccounts(context.Context) ([]string, error)
+	PersonalSign(context.Context, string, string, string) (string, error)
+	PersonalEcRecover(context.Context, string, string) (string, error)
+	PersonalImportRawKey(context.Context, string, string) (string, error)
+	PersonalUnlockAccount(context.Context, string, string, time.Duration) (bool, error)
+	PersonalLockAccount(context.Context, string) (bool, error)
+	GetBalance(context.Context, string) (*big.Int, error)
+	RunWorkload(context.Context, string, string, *big.Int, uint64, *big.Int, string, int, int)
 	NodeSyncProgress(context.Context) (bool, uint64, uint64, error)
+	GetTransaction(context.Context, string) (*TransactionRecord, error)
+	ListTransactions(context.Context, TransactionFilter) ([]TransactionRecord, error)
+	CancelTransaction(context.Context, string) (string, error)
+	SpeedUpTransaction(context.Context, string, *big.Int) (string, error)
+	Passthrough(context.Context, string, json.RawMessage) (json.RawMessage, error)
 
 	Web3ClientVersion(context.Context, interface{}) (interface{}, error)
 	Web3Sha3(context.Context, interface{}) (interface{}, error)
@@ -52,7 +64,8 @@ type TransactionExecutorService interface {
 	EthGetUncleCountByBlockNumber(context.Context, interface{}) (interface{}, error)
 	EthGetCode(context.Context, interface{}) (interface{}, error)
 	EthSign(context.Context, string, string) (interface{}, error)
-	EthSignTransaction(context.Context, string, string, int64, uint64, int64, string)(interface{}, error)
+	EthSignTypedData(context.Context, string, TypedData, bool) (interface{}, error)
+	EthSignTransaction(context.Context, string, string, *big.Int, uint64, *big.Int, string, *uint64) (interface{}, error)
 	EthSendRawTransaction(context.Context, interface{}) (interface{}, error)
 	EthCall(context.Context, interface{}) (interface{}, error)
 	EthEstimateGas(context.Context, interface{}) (interface{}, error)
@@ -81,11 +94,27 @@ type transactionExecutorService struct {
 	vaultClient   *vault.Client
 	quorumClient  quorum.Client
 	quorumAddress string
-	keystore      *keystore.KeyStore
-	accountCache  map[string]accounts.Account
-}
-
-// Currently proof of concept only
+	// Optional websocket connection to the node for subscriptions
+	subscriber quorum.Client
+	signer     Signer
+	nonces     *nonceManager
+	db         *BoltDB
+	gas        gasConfig
+	// Methods without a local handler are forwarded to the node if allowed
+	passthroughMethods *methodFilter
+	// Stores the payloads of private transactions; nil disables them
+	txManager *txManager
+	// Signs with EIP-155 replay protection if set; nil keeps homestead
+	// signatures, which Quorum networks need
+	chainID *big.Int
+	// Limits eth_accounts to the accounts an authenticated user may use
+	accountsPerUser bool
+	// Adds the node's own accounts to eth_accounts
+	nodeAccounts bool
+}
+
+// Currently proof of concept only. The single key predates per-account keys
+// and stays where it has always been, whatever -vault-key-path is set to.
 func (svc transactionExecutorService) GetVaultKey(_ context.Context) (string, error) {
 	pathArg := "keys/singleton"
 	vault := svc.vaultClient.Logical()
@@ -94,89 +123,241 @@ func (svc transactionExecutorService) GetVaultKey(_ context.Context) (string, er
 		log.Println(err)
 		return "", ErrVault
 	}
-	key, present := secret.Data["key"]
-	if !present {
-		log.Fatal("Vault entry found but key not present")
+	if secret == nil {
+		return "", ErrVaultKeyMissing
+	}
+	key, ok := secret.Data["key"].(string)
+	if !ok {
+		log.Println("Error: Vault entry found but key not present at " + pathArg)
+		return "", ErrVaultKeyMissing
 	}
-	return key.(string), nil
+	return key, nil
 }
 
-func (svc transactionExecutorService) GenerateKey(_ context.Context) (string, error) {
-	// TODO: Use a real password
-	password := ""
-	account, err := svc.keystore.NewAccount(password)
-	if err != nil {
-		log.Println(err)
-		return "", ErrKeystore
+// GenerateKey creates an account without a passphrase
+func (svc transactionExecutorService) GenerateKey(ctx context.Context) (string, error) {
+	return svc.PersonalNewAccount(ctx, "")
+}
+
+// account looks up the signer account for a hex address
+func (svc transactionExecutorService) account(address string) (accounts.Account, error) {
+	addr := ethCommon.HexToAddress(address)
+	if !svc.signer.HasAddress(addr) {
+		return accounts.Account{}, ErrAccountMissing
+	}
+
+	return accounts.Account{Address: addr}, nil
+}
+
+// subscriptionClient returns the client to use for node subscriptions
+func (svc transactionExecutorService) subscriptionClient() quorum.Client {
+	if svc.subscriber != nil {
+		return svc.subscriber
 	}
 
-	address := "0x" + hex.EncodeToString(account.Address.Bytes())
-	svc.accountCache[address] = account
-	return address, nil
+	return svc.quorumClient
 }
 
-func (svc transactionExecutorService) ExecuteTransaction(ctx context.Context, from string, to string, amount int64, gasLimit uint64, gasPrice int64, hexData string) (string, error) {
-	// TODO: Replace with vault backend
-	// account, present := svc.accountCache[from]
-	// if !present {
-	//   return "", ErrAccountMissing
-	// }
+// signTransaction reserves the account's next nonce, or the given one, and
+// signs a transaction with it. If the nonce was already used by a pending
+// transaction, that transaction's journal record is returned as well.
+// Sign-only transactions may never be sent, so they take the next nonce
+// without reserving it.
+func (svc transactionExecutorService) signTransaction(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, explicitNonce *uint64, signOnly bool) (*types.Transaction, *TransactionRecord, error) {
+	account, err := svc.account(from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nonce uint64
+	var replaces *TransactionRecord
+	if signOnly {
+		nonce, err = svc.peekNonce(ctx, account.Address, explicitNonce)
+	} else {
+		nonce, replaces, err = svc.reserveNonce(ctx, account.Address, explicitNonce)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 
-	accs := svc.keystore.Accounts()
-	var account accounts.Account
+	data := ethCommon.FromHex(hexData)
 
-	for _, a := range accs {
-		if ethCommon.HexToAddress(from) == a.Address {
-			account = a
-			break
+	var tx *types.Transaction
+	if to == "" {
+		tx = types.NewContractCreation(nonce, amount, gasLimit, gasPrice, data)
+	} else {
+		tx = types.NewTransaction(nonce, ethCommon.HexToAddress(to), amount, gasLimit, gasPrice, data)
+	}
+	tx, err = svc.signer.SignTx(account, tx, svc.chainID)
+	if err != nil {
+		if replaces == nil && !signOnly {
+			svc.nonces.Release(account.Address, nonce)
 		}
+		return nil, nil, signerError(err)
 	}
 
-	password := ""
+	return tx, replaces, nil
+}
+
+// peekNonce returns the account's next nonce, or checks that an explicit one
+// is not mined yet, without claiming it
+func (svc transactionExecutorService) peekNonce(ctx context.Context, address ethCommon.Address, explicitNonce *uint64) (uint64, error) {
+	if explicitNonce == nil {
+		nonce, err := svc.nonces.Peek(ctx, address)
+		if err != nil {
+			log.Println("Error: PendingNonceAt")
+			log.Println(err)
+			return 0, nodeError(ErrQuorum, err)
+		}
+		return nonce, nil
+	}
 
-	nonce, err := svc.quorumClient.PendingNonceAt(ctx, account.Address)
+	mined, err := svc.quorumClient.NonceAt(ctx, address, nil)
 	if err != nil {
-		log.Println("Error: PendingNonceAt")
+		log.Println("Error: NonceAt")
 		log.Println(err)
-		return "", ErrQuorum
+		return 0, nodeError(ErrQuorum, err)
+	}
+	if *explicitNonce < mined {
+		return 0, ErrNonceTooLow
 	}
 
-	data := ethCommon.FromHex(hexData)
+	return *explicitNonce, nil
+}
+
+// reserveNonce takes the account's next nonce, or checks and claims an explicit
+// one. An explicit nonce that was already handed out must belong to a pending
+// or signed journal transaction, which is returned so it can be marked as
+// replaced.
+func (svc transactionExecutorService) reserveNonce(ctx context.Context, address ethCommon.Address, explicitNonce *uint64) (uint64, *TransactionRecord, error) {
+	if explicitNonce == nil {
+		nonce, err := svc.nonces.Next(ctx, address)
+		if err != nil {
+			log.Println("Error: PendingNonceAt")
+			log.Println(err)
+			return 0, nil, nodeError(ErrQuorum, err)
+		}
+		return nonce, nil, nil
+	}
 
-	tx := types.NewTransaction(nonce, ethCommon.HexToAddress(to), big.NewInt(amount), gasLimit, big.NewInt(gasPrice), data)
-	// Chain ID must be nil for quorum
-	tx, err = svc.keystore.SignTxWithPassphrase(account, password, tx, nil)
+	nonce := *explicitNonce
+	reused, err := svc.nonces.Reserve(ctx, address, nonce)
+	if err == ErrNonceTooLow {
+		return 0, nil, err
+	}
 	if err != nil {
-		log.Println("Error: Signing")
+		log.Println("Error: NonceAt")
 		log.Println(err)
-		return "", ErrSigning
+		return 0, nil, nodeError(ErrQuorum, err)
+	}
+	if !reused {
+		return nonce, nil, nil
+	}
+
+	if svc.db == nil {
+		return 0, nil, ErrNonceInUse
+	}
+
+	records, err := svc.db.openTransactions(TransactionFilter{Account: address.Hex()})
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range records {
+		r := &records[i]
+		if strings.EqualFold(r.From, address.Hex()) && uint64(r.Nonce) == nonce && (r.Status == TxPending || r.Status == TxSigned) {
+			return nonce, r, nil
+		}
+	}
+
+	return 0, nil, ErrNonceInUse
+}
+
+// ExecuteTransaction signs and sends a transaction. A zero gas limit is
+// estimated and a nil gas price is chosen by the executor.
+func (svc transactionExecutorService) ExecuteTransaction(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, nonce *uint64) (string, error) {
+	if _, err := svc.userAccount(ctx, from); err != nil {
+		return "", err
+	}
+
+	gasLimit, gasPrice, defaults, err := svc.fillGas(ctx, from, to, amount, gasLimit, gasPrice, ethCommon.FromHex(hexData))
+	if err != nil {
+		return "", err
+	}
+	refundSpend, err := svc.checkPolicy(ctx, from, policyTx{to, amount, gasLimit, gasPrice, ethCommon.FromHex(hexData)}, true)
+	if err != nil {
+		return "", err
+	}
+	refundQuota, err := svc.useTxQuota(ctx)
+	if err != nil {
+		refundSpend()
+		return "", err
+	}
+	refund := func() {
+		refundQuota()
+		refundSpend()
+	}
+
+	tx, replaces, err := svc.signTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexData, nonce, false)
+	if err != nil {
+		refund()
+		return "", err
 	}
+
 	err = svc.quorumClient.SendTransaction(ctx, tx)
+	if isNonceTooLow(err) && nonce == nil {
+		// Another client used this account; pick up the node's nonce and retry once
+		log.Println("Nonce too low, resynchronising", from)
+		if err = svc.nonces.Resync(ctx, ethCommon.HexToAddress(from)); err != nil {
+			refund()
+			log.Println("Error: PendingNonceAt")
+			log.Println(err)
+			return "", nodeError(ErrQuorum, err)
+		}
+
+		tx, _, err = svc.signTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexData, nil, false)
+		if err != nil {
+			refund()
+			return "", err
+		}
+		err = svc.quorumClient.SendTransaction(ctx, tx)
+	}
 	if err != nil {
+		refund()
+		if replaces == nil {
+			svc.nonces.Release(ethCommon.HexToAddress(from), tx.Nonce())
+		}
 		log.Println("Error: SendTransaction")
 		log.Println(err)
-		return "", ErrQuorum
+		return "", nodeError(ErrQuorum, err)
+	}
+
+	if replaces != nil {
+		if _, err := svc.storeReplacement(ctx, replaces, tx); err != nil {
+			log.Println("Error: recording replacement", tx.Hash().Hex(), err)
+		}
+	} else {
+		svc.recordTransaction(ctx, tx, ethCommon.HexToAddress(from), TxPending, defaults)
 	}
 	txHash := tx.Hash().String()
 	return txHash, nil
 }
 
-func (svc transactionExecutorService) GetBalance(ctx context.Context, address string) (int64, error) {
-	account, present := svc.accountCache[address]
-	if !present {
-		return int64(0), ErrAccountMissing
+func (svc transactionExecutorService) GetBalance(ctx context.Context, address string) (*big.Int, error) {
+	account, err := svc.account(address)
+	if err != nil {
+		return nil, err
 	}
 	var blockNumber *big.Int
 	blockNumber = nil
 	balance, err := svc.quorumClient.BalanceAt(ctx, account.Address, blockNumber)
 	if err != nil {
 		log.Println(err)
-		return int64(0), ErrQuorum
+		return nil, nodeError(ErrQuorum, err)
 	}
-	return balance.Int64(), nil
+	return balance, nil
 }
 
-func (svc transactionExecutorService) RunWorkload(_ context.Context, from string, to string, amount int64, gasLimit uint64, gasPrice int64, hexData string, sleepSeconds int, numTransactions int) {
+func (svc transactionExecutorService) RunWorkload(_ context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, sleepSeconds int, numTransactions int) {
 	ctx := context.Background()
 	go svc.workload(ctx, from, to, amount, gasLimit, gasPrice, hexData, sleepSeconds, numTransactions)
 }
@@ -185,7 +366,7 @@ func (svc transactionExecutorService) NodeSyncProgress(ctx context.Context) (boo
 	syncProgress, err := svc.quorumClient.SyncProgress(ctx)
 	if err != nil {
 		log.Println(err)
-		return false, uint64(0), uint64(0), ErrQuorum
+		return false, uint64(0), uint64(0), nodeError(ErrQuorum, err)
 	}
 
 	// Syncing is complete
@@ -197,10 +378,10 @@ func (svc transactionExecutorService) NodeSyncProgress(ctx context.Context) (boo
 	return true, syncProgress.CurrentBlock, syncProgress.HighestBlock, nil
 }
 
-func (svc transactionExecutorService) workload(ctx context.Context, from string, to string, amount int64, gasLimit uint64, gasPrice int64, hexData string, sleepSeconds int, numTransactions int) {
+func (svc transactionExecutorService) workload(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, sleepSeconds int, numTransactions int) {
 	sleepDuration := time.Duration(sleepSeconds) * time.Second
 	for i := 0; i < numTransactions; i++ {
-		_, err := svc.ExecuteTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexData)
+		_, err := svc.ExecuteTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexData, nil)
 		if err != nil {
 			log.Printf("Workload Error: %v", err)
 		}
@@ -209,26 +390,54 @@ func (svc transactionExecutorService) workload(ctx context.Context, from string,
 }
 
 // ErrVault is returned when there is an error accessing vault.
-var ErrVault = errors.New("error accessing vault")
+var ErrVault = newRPCError(ErrCodeServer, "error accessing vault")
+
+// ErrVaultKeyMissing is returned when vault has no key at the legacy path.
+var ErrVaultKeyMissing = newRPCError(ErrCodeResourceNotFound, "vault key not found")
 
 // ErrKeystore is returned when there is an error using the keystore
-var ErrKeystore = errors.New("error using keystore")
+var ErrKeystore = newRPCError(ErrCodeServer, "error using keystore")
 
 // ErrQuorum is returned when there is an error using the quorum client
-var ErrQuorum = errors.New("error using quorum client")
+var ErrQuorum = newRPCError(ErrCodeServer, "error using quorum client")
 
 // ErrAccountMissing is returned when the requested account could not be found
-var ErrAccountMissing = errors.New("account not found")
+var ErrAccountMissing = newRPCError(ErrCodeResourceNotFound, "account not found")
 
 // ErrSigning is returned when there is an error signing the transaction
-var ErrSigning = errors.New("error signing transaction")
+var ErrSigning = newRPCError(ErrCodeServer, "error signing transaction")
+
+// ErrInvalidParams is returned when the RPC params cannot be interpreted
+var ErrInvalidParams = newRPCError(jsonrpc.InvalidParamsError, "invalid params")
+
+// ErrTransactionMissing is returned when a transaction is not in the journal
+var ErrTransactionMissing = newRPCError(ErrCodeResourceNotFound, "transaction not found")
+
+// ErrTransactionNotPending is returned when replacing a transaction that is no longer pending
+var ErrTransactionNotPending = newRPCError(ErrCodeServer, "transaction is not pending")
+
+// ErrGasPriceTooLow is returned when a replacement would not raise the gas price
+var ErrGasPriceTooLow = newRPCError(jsonrpc.InvalidParamsError, "gas price must be higher than the pending transaction's")
+
+// ErrJournalDisabled is returned when the transaction journal has no database
+var ErrJournalDisabled = newRPCError(ErrCodeMethodNotSupported, "transaction journal is not enabled")
+
+// ErrChainIDMismatch is returned for raw transactions signed for another chain
+var ErrChainIDMismatch = newRPCError(jsonrpc.InvalidParamsError, "invalid params: chain ID does not match the executor's")
+
+// ErrNonceTooLow is returned when an explicit nonce has already been mined
+var ErrNonceTooLow = newRPCError(jsonrpc.InvalidParamsError, "invalid params: nonce: already used on chain")
+
+// ErrNonceInUse is returned when an explicit nonce was handed out for a
+// transaction that is no longer pending in the journal, so cannot be replaced
+var ErrNonceInUse = newRPCError(jsonrpc.InvalidParamsError, "invalid params: nonce: already used by the executor")
 
 func (svc transactionExecutorService) Web3ClientVersion(ctx context.Context, params interface{}) (interface{}, error) {
 	u, _ := url.Parse(svc.quorumAddress)
 	client := jsonrpc.NewClient(u, "web3_clientVersion")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -239,7 +448,7 @@ func (svc transactionExecutorService) Web3Sha3(ctx context.Context, params inter
 	client := jsonrpc.NewClient(u, "web3_sha3")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -250,7 +459,7 @@ func (svc transactionExecutorService) NetVersion(ctx context.Context, params int
 	client := jsonrpc.NewClient(u, "net_version")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -261,7 +470,7 @@ func (svc transactionExecutorService) NetPeerCount(ctx context.Context, params i
 	client := jsonrpc.NewClient(u, "net_peerCount")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -272,7 +481,7 @@ func (svc transactionExecutorService) NetListening(ctx context.Context, params i
 	client := jsonrpc.NewClient(u, "net_listening")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -283,7 +492,7 @@ func (svc transactionExecutorService) EthProtocolVersion(ctx context.Context, pa
 	client := jsonrpc.NewClient(u, "eth_protocolVersion")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -294,7 +503,7 @@ func (svc transactionExecutorService) EthSyncing(ctx context.Context, params int
 	client := jsonrpc.NewClient(u, "eth_syncing")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -305,7 +514,7 @@ func (svc transactionExecutorService) EthCoinbase(ctx context.Context, params in
 	client := jsonrpc.NewClient(u, "eth_coinbase")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -316,7 +525,7 @@ func (svc transactionExecutorService) EthMining(ctx context.Context, params inte
 	client := jsonrpc.NewClient(u, "eth_mining")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -327,7 +536,7 @@ func (svc transactionExecutorService) EthHashrate(ctx context.Context, params in
 	client := jsonrpc.NewClient(u, "eth_hashrate")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -338,18 +547,7 @@ func (svc transactionExecutorService) EthGasPrice(ctx context.Context, params in
 	client := jsonrpc.NewClient(u, "eth_gasPrice")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-func (svc transactionExecutorService) EthAccounts(ctx context.Context, params interface{}) (interface{}, error) {
-	u, _ := url.Parse(svc.quorumAddress)
-	client := jsonrpc.NewClient(u, "eth_accounts")
-	res, err := client.Endpoint()(ctx, params)
-	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -360,7 +558,7 @@ func (svc transactionExecutorService) EthBlockNumber(ctx context.Context, params
 	client := jsonrpc.NewClient(u, "eth_blockNumber")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -371,7 +569,7 @@ func (svc transactionExecutorService) EthGetBalance(ctx context.Context, params
 	client := jsonrpc.NewClient(u, "eth_getBalance")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -382,7 +580,7 @@ func (svc transactionExecutorService) EthGetStorageAt(ctx context.Context, param
 	client := jsonrpc.NewClient(u, "eth_getStorageAt")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -393,7 +591,7 @@ func (svc transactionExecutorService) EthGetTransactionCount(ctx context.Context
 	client := jsonrpc.NewClient(u, "eth_getTransactionCount")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -404,7 +602,7 @@ func (svc transactionExecutorService) EthGetBlockTransactionCountByHash(ctx cont
 	client := jsonrpc.NewClient(u, "eth_getBlockTransactionCountByHash")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -415,7 +613,7 @@ func (svc transactionExecutorService) EthGetBlockTransactionCountByNumber(ctx co
 	client := jsonrpc.NewClient(u, "eth_getBlockTransactionCountByNumber")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -426,7 +624,7 @@ func (svc transactionExecutorService) EthGetUncleCountByBlockHash(ctx context.Co
 	client := jsonrpc.NewClient(u, "eth_getUncleCountByBlockHash")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -437,7 +635,7 @@ func (svc transactionExecutorService) EthGetUncleCountByBlockNumber(ctx context.
 	client := jsonrpc.NewClient(u, "eth_getUncleCountByBlockNumber")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -448,7 +646,7 @@ func (svc transactionExecutorService) EthGetCode(ctx context.Context, params int
 	client := jsonrpc.NewClient(u, "eth_getCode")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -460,20 +658,14 @@ func signHash(data []byte) []byte {
 }
 
 func (svc transactionExecutorService) EthSign(ctx context.Context, address, data string) (interface{}, error) {
-	accs := svc.keystore.Accounts()
-	var account accounts.Account
-
-	for _, a := range accs {
-		if ethCommon.HexToAddress(address) == a.Address {
-			account = a
-			break
-		}
+	account, err := svc.userAccount(ctx, address)
+	if err != nil {
+		return nil, err
 	}
 
-	password := ""
-	signature, err := svc.keystore.SignHashWithPassphrase(account, password, signHash(ethCommon.FromHex(data)))
+	signature, err := svc.signer.SignHash(account, signHash(ethCommon.FromHex(data)))
 	if err != nil {
-		return nil, err
+		return nil, signerError(err)
 	}
 
 	signature[64] += 27
@@ -481,39 +673,28 @@ func (svc transactionExecutorService) EthSign(ctx context.Context, address, data
 	return ethCommon.ToHex(signature), nil
 }
 
-func (svc transactionExecutorService) EthSignTransaction(ctx context.Context, from string, to string, amount int64, gasLimit uint64, gasPrice int64, hexData string)(interface{}, error) {
-	accs := svc.keystore.Accounts()
-	var account accounts.Account
-
-	for _, a := range accs {
-		if ethCommon.HexToAddress(from) == a.Address {
-			account = a
-			break
-		}
+func (svc transactionExecutorService) EthSignTransaction(ctx context.Context, from string, to string, amount *big.Int, gasLimit uint64, gasPrice *big.Int, hexData string, nonce *uint64) (interface{}, error) {
+	if _, err := svc.userAccount(ctx, from); err != nil {
+		return "", err
 	}
 
-	password := ""
-	nonce, err := svc.quorumClient.PendingNonceAt(ctx, account.Address)
+	gasLimit, gasPrice, defaults, err := svc.fillGas(ctx, from, to, amount, gasLimit, gasPrice, ethCommon.FromHex(hexData))
 	if err != nil {
-		log.Println("Error: PendingNonceAt")
-		log.Println(err)
-		return "", ErrQuorum
+		return "", err
+	}
+	// The quota and daily value are charged when the transaction is sent
+	if _, err := svc.checkPolicy(ctx, from, policyTx{to, amount, gasLimit, gasPrice, ethCommon.FromHex(hexData)}, false); err != nil {
+		return "", err
 	}
 
-	data := ethCommon.FromHex(hexData)
-
-	tx := types.NewTransaction(nonce, ethCommon.HexToAddress(to), big.NewInt(amount), gasLimit, big.NewInt(gasPrice), data)
-
-	// Chain ID must be nil for quorum
-      tx, err = svc.keystore.SignTxWithPassphrase(account, password, tx, nil)
-
+	tx, _, err := svc.signTransaction(ctx, from, to, amount, gasLimit, gasPrice, hexData, nonce, true)
 	if err != nil {
-		log.Println("Error: Signing")
-		log.Println(err)
-		return "", ErrSigning
+		return "", err
 	}
 
-      rlpData, err := ethRlp.EncodeToBytes(tx)
+	svc.recordTransaction(ctx, tx, ethCommon.HexToAddress(from), TxSigned, defaults)
+
+	rlpData, err := ethRlp.EncodeToBytes(tx)
 
 	if err != nil {
 		log.Println("Error: RLP encoding")
@@ -521,17 +702,32 @@ func (svc transactionExecutorService) EthSignTransaction(ctx context.Context, fr
 		return "", err
 	}
 
-      str := ethCommon.ToHex(rlpData)
+	str := ethCommon.ToHex(rlpData)
 
-      return str, nil
+	return str, nil
 }
 
 func (svc transactionExecutorService) EthSendRawTransaction(ctx context.Context, params interface{}) (interface{}, error) {
+	if err := svc.checkChainID(params); err != nil {
+		return nil, err
+	}
+	refundSpend, err := svc.checkRawPolicy(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	refundQuota, err := svc.useTxQuota(ctx)
+	if err != nil {
+		refundSpend()
+		return nil, err
+	}
+
 	u, _ := url.Parse(svc.quorumAddress)
 	client := jsonrpc.NewClient(u, "eth_sendRawTransaction")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		refundQuota()
+		refundSpend()
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -542,7 +738,7 @@ func (svc transactionExecutorService) EthCall(ctx context.Context, params interf
 	client := jsonrpc.NewClient(u, "eth_call")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -553,7 +749,7 @@ func (svc transactionExecutorService) EthEstimateGas(ctx context.Context, params
 	client := jsonrpc.NewClient(u, "eth_estimateGas")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -564,7 +760,7 @@ func (svc transactionExecutorService) EthGetBlockByHash(ctx context.Context, par
 	client := jsonrpc.NewClient(u, "eth_getBlockByHash")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -575,7 +771,7 @@ func (svc transactionExecutorService) EthGetBlockByNumber(ctx context.Context, p
 	client := jsonrpc.NewClient(u, "eth_getBlockByNumber")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -586,7 +782,7 @@ func (svc transactionExecutorService) EthGetTransactionByHash(ctx context.Contex
 	client := jsonrpc.NewClient(u, "eth_getTransactionByHash")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -597,7 +793,7 @@ func (svc transactionExecutorService) EthGetTransactionByBlockHashAndIndex(ctx c
 	client := jsonrpc.NewClient(u, "eth_getTransactionByBlockHashAndIndex")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -608,7 +804,7 @@ func (svc transactionExecutorService) EthGetTransactionByBlockNumberAndIndex(ctx
 	client := jsonrpc.NewClient(u, "eth_getTransactionByBlockNumberAndIndex")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -619,7 +815,7 @@ func (svc transactionExecutorService) EthGetTransactionReceipt(ctx context.Conte
 	client := jsonrpc.NewClient(u, "eth_getTransactionReceipt")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -630,7 +826,7 @@ func (svc transactionExecutorService) EthGetUncleByBlockHashAndIndex(ctx context
 	client := jsonrpc.NewClient(u, "eth_getUncleByBlockHashAndIndex")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -641,7 +837,7 @@ func (svc transactionExecutorService) EthGetUncleByBlockNumberAndIndex(ctx conte
 	client := jsonrpc.NewClient(u, "eth_getUncleByBlockNumberAndIndex")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -652,7 +848,7 @@ func (svc transactionExecutorService) EthNewFilter(ctx context.Context, params i
 	client := jsonrpc.NewClient(u, "eth_newFilter")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -663,7 +859,7 @@ func (svc transactionExecutorService) EthNewBlockFilter(ctx context.Context, par
 	client := jsonrpc.NewClient(u, "eth_newBlockFilter")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -674,7 +870,7 @@ func (svc transactionExecutorService) EthNewPendingTransactionFilter(ctx context
 	client := jsonrpc.NewClient(u, "eth_newPendingTransactionFilter")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -685,7 +881,7 @@ func (svc transactionExecutorService) EthUninstallFilter(ctx context.Context, pa
 	client := jsonrpc.NewClient(u, "eth_uninstallFilter")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -696,7 +892,7 @@ func (svc transactionExecutorService) EthGetFilterChanges(ctx context.Context, p
 	client := jsonrpc.NewClient(u, "eth_getFilterChanges")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -707,7 +903,7 @@ func (svc transactionExecutorService) EthGetFilterLogs(ctx context.Context, para
 	client := jsonrpc.NewClient(u, "eth_getFilterLogs")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -718,7 +914,7 @@ func (svc transactionExecutorService) EthGetLogs(ctx context.Context, params int
 	client := jsonrpc.NewClient(u, "eth_getLogs")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -729,7 +925,7 @@ func (svc transactionExecutorService) EthGetWork(ctx context.Context, params int
 	client := jsonrpc.NewClient(u, "eth_getWork")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -740,7 +936,7 @@ func (svc transactionExecutorService) EthSubmitWork(ctx context.Context, params
 	client := jsonrpc.NewClient(u, "eth_submitWork")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -751,7 +947,7 @@ func (svc transactionExecutorService) EthSubmitHashrate(ctx context.Context, par
 	client := jsonrpc.NewClient(u, "eth_submitHashrate")
 	res, err := client.Endpoint()(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nodeError(ErrQuorum, err)
 	}
 
 	return res, nil
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/eximchain/eth-client/quorum"
+	ethereum "github.com/eximchain/go-ethereum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/eximchain/go-ethereum/crypto"
+	ethRlp "github.com/eximchain/go-ethereum/rlp"
+)
+
+// fakeQuorum implements the parts of quorum.Client used by the service.
+// Calling any other method panics on the nil embedded interface.
+type fakeQuorum struct {
+	quorum.Client
+
+	mu     sync.Mutex
+	nonces map[ethCommon.Address]uint64
+	// Mined nonces, returned by NonceAt
+	mined    map[ethCommon.Address]uint64
+	sent     []*types.Transaction
+	pool     map[ethCommon.Hash]*types.Transaction
+	receipts map[ethCommon.Hash]*types.Receipt
+	// Returned by SendTransaction instead of accepting the transaction
+	sendErr error
+	// Returned by EstimateGas and SuggestGasPrice
+	gasEstimate uint64
+	gasPrice    *big.Int
+	// Returned by NetworkID
+	networkID *big.Int
+}
+
+// testTxSigner recovers senders of both homestead and EIP-155 transactions
+func testTxSigner(tx *types.Transaction) types.Signer {
+	if tx.Protected() {
+		return types.NewEIP155Signer(tx.ChainId())
+	}
+
+	return types.HomesteadSigner{}
+}
+
+func newFakeQuorum() *fakeQuorum {
+	return &fakeQuorum{
+		nonces:      make(map[ethCommon.Address]uint64),
+		mined:       make(map[ethCommon.Address]uint64),
+		gasEstimate: 21000,
+		gasPrice:    big.NewInt(1),
+		pool:        make(map[ethCommon.Hash]*types.Transaction),
+		receipts:    make(map[ethCommon.Hash]*types.Receipt),
+	}
+}
+
+func (q *fakeQuorum) TransactionReceipt(_ context.Context, hash ethCommon.Hash) (*types.Receipt, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	receipt, present := q.receipts[hash]
+	if !present {
+		return nil, ethereum.NotFound
+	}
+
+	return receipt, nil
+}
+
+func (q *fakeQuorum) PendingNonceAt(_ context.Context, account ethCommon.Address) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.nonces[account], nil
+}
+
+func (q *fakeQuorum) NonceAt(_ context.Context, account ethCommon.Address, _ *big.Int) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.mined[account], nil
+}
+
+func (q *fakeQuorum) EstimateGas(_ context.Context, _ ethereum.CallMsg) (uint64, error) {
+	return q.gasEstimate, nil
+}
+
+func (q *fakeQuorum) NetworkID(_ context.Context) (*big.Int, error) {
+	return q.networkID, nil
+}
+
+func (q *fakeQuorum) SuggestGasPrice(_ context.Context) (*big.Int, error) {
+	return q.gasPrice, nil
+}
+
+func (q *fakeQuorum) SendTransaction(_ context.Context, tx *types.Transaction) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.sendErr != nil {
+		return q.sendErr
+	}
+
+	from, err := types.Sender(testTxSigner(tx), tx)
+	if err != nil {
+		return err
+	}
+
+	if tx.Nonce() < q.nonces[from] {
+		for _, pending := range q.pool {
+			if pending.Nonce() == tx.Nonce() && pending.GasPrice().Cmp(tx.GasPrice()) < 0 {
+				delete(q.pool, pending.Hash())
+				q.pool[tx.Hash()] = tx
+				q.sent = append(q.sent, tx)
+				return nil
+			}
+		}
+		return errors.New("nonce too low")
+	}
+
+	q.nonces[from] = tx.Nonce() + 1
+	q.sent = append(q.sent, tx)
+	q.pool[tx.Hash()] = tx
+	return nil
+}
+
+func (q *fakeQuorum) SendRawTransaction(ctx context.Context, tx *types.Transaction) error {
+	return q.SendTransaction(ctx, tx)
+}
+
+func (q *fakeQuorum) TransactionByHash(_ context.Context, hash ethCommon.Hash) (*types.Transaction, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tx, present := q.pool[hash]
+	if !present {
+		return nil, false, ethereum.NotFound
+	}
+
+	return tx, true, nil
+}
+
+// evict drops a transaction from the pool and forgets its nonce, as a node
+// restart would
+func (q *fakeQuorum) evict(tx *types.Transaction) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	from, _ := types.Sender(testTxSigner(tx), tx)
+	delete(q.pool, tx.Hash())
+	q.nonces[from] = tx.Nonce()
+}
+
+func NewTestService() (transactionExecutorService, *fakeQuorum) {
+	q := newFakeQuorum()
+	svc := transactionExecutorService{
+		signer:       newMemorySigner(),
+		nonces:       newNonceManager(q, nil),
+		quorumClient: q,
+	}
+
+	return svc, q
+}
+
+func TestExecuteTransaction(t *testing.T) {
+	svc, q := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	to := "0x0000000000000000000000000000000000000001"
+	for i := 0; i < 2; i++ {
+		txHash, err := svc.ExecuteTransaction(ctx, from, to, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+		if err != nil {
+			t.Fatalf("cannot execute transaction %s", err)
+		}
+
+		if q.sent[i].Hash().String() != txHash || q.sent[i].Nonce() != uint64(i) {
+			t.Fatalf("unexpected transaction %s nonce %d", txHash, q.sent[i].Nonce())
+		}
+	}
+
+	_, err = svc.ExecuteTransaction(ctx, to, from, big.NewInt(1), 21000, big.NewInt(0), "", nil)
+	if err != ErrAccountMissing {
+		t.Fatalf("expected %s, got %v", ErrAccountMissing, err)
+	}
+}
+
+func TestEthSign(t *testing.T) {
+	svc, _ := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	res, err := svc.EthSign(ctx, from, "0xdeadbeef")
+	if err != nil {
+		t.Fatalf("cannot sign %s", err)
+	}
+
+	signature := ethCommon.FromHex(res.(string))
+	if len(signature) != 65 {
+		t.Fatalf("unexpected signature length %d", len(signature))
+	}
+	signature[64] -= 27
+
+	pub, err := crypto.SigToPub(signHash(ethCommon.FromHex("0xdeadbeef")), signature)
+	if err != nil || crypto.PubkeyToAddress(*pub) != ethCommon.HexToAddress(from) {
+		t.Fatalf("signature does not recover to %s", from)
+	}
+}
+
+func TestEthSignTransaction(t *testing.T) {
+	svc, q := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	res, err := svc.EthSignTransaction(ctx, from, "0x0000000000000000000000000000000000000001", big.NewInt(5), 21000, big.NewInt(0), "0x01", nil)
+	if err != nil {
+		t.Fatalf("cannot sign transaction %s", err)
+	}
+
+	tx := new(types.Transaction)
+	if err := ethRlp.DecodeBytes(ethCommon.FromHex(res.(string)), tx); err != nil {
+		t.Fatalf("cannot decode transaction %s", err)
+	}
+
+	sender, err := types.Sender(testTxSigner(tx), tx)
+	if err != nil || sender != ethCommon.HexToAddress(from) {
+		t.Fatalf("transaction signed by %s, expected %s", sender.Hex(), from)
+	}
+
+	if tx.Value().Int64() != 5 || len(q.sent) != 0 {
+		t.Fatalf("unexpected transaction value %s or sent %d", tx.Value(), len(q.sent))
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/eximchain/go-ethereum/accounts"
+	"github.com/eximchain/go-ethereum/accounts/keystore"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/eximchain/go-ethereum/crypto"
+)
+
+// Signer holds the accounts the executor can sign with
+type Signer interface {
+	Accounts() ([]accounts.Account, error)
+	HasAddress(ethCommon.Address) bool
+	NewAccount(passphrase string) (accounts.Account, error)
+	ImportKey(key *ecdsa.PrivateKey, passphrase string) (accounts.Account, error)
+	// Unlock lets SignTx and SignHash use an account with a passphrase until
+	// the timeout passes, or until Lock if the timeout is zero
+	Unlock(account accounts.Account, passphrase string, timeout time.Duration) error
+	Lock(ethCommon.Address) error
+	SignTx(accounts.Account, *types.Transaction, *big.Int) (*types.Transaction, error)
+	SignHash(accounts.Account, []byte) ([]byte, error)
+	SignHashWithPassphrase(accounts.Account, string, []byte) ([]byte, error)
+}
+
+// keystoreSigner signs with keys from a geth keystore directory. Accounts
+// created with an empty passphrase can always sign; others must be unlocked.
+type keystoreSigner struct {
+	keystore *keystore.KeyStore
+
+	mu sync.Mutex
+	// Whether accounts have an empty passphrase, recorded when they are
+	// created, imported or unlocked
+	emptyPassphrase map[ethCommon.Address]bool
+}
+
+func newKeystoreSigner(ks *keystore.KeyStore) *keystoreSigner {
+	return &keystoreSigner{
+		keystore:        ks,
+		emptyPassphrase: make(map[ethCommon.Address]bool),
+	}
+}
+
+func (s *keystoreSigner) Accounts() ([]accounts.Account, error) {
+	return s.keystore.Accounts(), nil
+}
+
+func (s *keystoreSigner) HasAddress(address ethCommon.Address) bool {
+	return s.keystore.HasAddress(address)
+}
+
+func (s *keystoreSigner) NewAccount(passphrase string) (accounts.Account, error) {
+	account, err := s.keystore.NewAccount(passphrase)
+	if err == nil {
+		s.recordPassphrase(account.Address, passphrase)
+	}
+	return account, err
+}
+
+func (s *keystoreSigner) ImportKey(key *ecdsa.PrivateKey, passphrase string) (accounts.Account, error) {
+	if s.keystore.HasAddress(crypto.PubkeyToAddress(key.PublicKey)) {
+		return accounts.Account{}, ErrAccountExists
+	}
+
+	account, err := s.keystore.ImportECDSA(key, passphrase)
+	if err == nil {
+		s.recordPassphrase(account.Address, passphrase)
+	}
+	return account, err
+}
+
+func (s *keystoreSigner) Unlock(account accounts.Account, passphrase string, timeout time.Duration) error {
+	err := s.keystore.TimedUnlock(account, passphrase, timeout)
+	if err == nil {
+		s.recordPassphrase(account.Address, passphrase)
+	}
+	return err
+}
+
+func (s *keystoreSigner) recordPassphrase(address ethCommon.Address, passphrase string) {
+	s.mu.Lock()
+	s.emptyPassphrase[address] = passphrase == ""
+	s.mu.Unlock()
+}
+
+// unlockEmpty unlocks a locked account that has an empty passphrase. An
+// account already in the keystore when the signer started is tried once and
+// the outcome remembered, so locked accounts do not pay for decrypting the
+// key on every signature.
+func (s *keystoreSigner) unlockEmpty(account accounts.Account) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if empty, known := s.emptyPassphrase[account.Address]; known && !empty {
+		return false
+	}
+
+	err := s.keystore.TimedUnlock(account, "", 0)
+	if err != nil && err != keystore.ErrDecrypt {
+		return false
+	}
+	s.emptyPassphrase[account.Address] = err == nil
+	return err == nil
+}
+
+func (s *keystoreSigner) Lock(address ethCommon.Address) error {
+	return s.keystore.Lock(address)
+}
+
+func (s *keystoreSigner) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signed, err := s.keystore.SignTx(account, tx, chainID)
+	if err == keystore.ErrLocked && s.unlockEmpty(account) {
+		signed, err = s.keystore.SignTx(account, tx, chainID)
+	}
+	return signed, err
+}
+
+func (s *keystoreSigner) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	signature, err := s.keystore.SignHash(account, hash)
+	if err == keystore.ErrLocked && s.unlockEmpty(account) {
+		signature, err = s.keystore.SignHash(account, hash)
+	}
+	return signature, err
+}
+
+func (s *keystoreSigner) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	return s.keystore.SignHashWithPassphrase(account, passphrase, hash)
+}
+
+// memorySigner keeps keys in memory only; they are lost on restart.
+// Intended for tests and local development. Passphrases behave as in
+// keystoreSigner.
+type memorySigner struct {
+	mu          sync.RWMutex
+	keys        map[ethCommon.Address]*ecdsa.PrivateKey
+	passphrases map[ethCommon.Address]string
+	// Unlock expiry times; the zero time never expires
+	unlocked map[ethCommon.Address]time.Time
+}
+
+func newMemorySigner() *memorySigner {
+	return &memorySigner{
+		keys:        make(map[ethCommon.Address]*ecdsa.PrivateKey),
+		passphrases: make(map[ethCommon.Address]string),
+		unlocked:    make(map[ethCommon.Address]time.Time),
+	}
+}
+
+func (s *memorySigner) Accounts() ([]accounts.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accs := make([]accounts.Account, 0, len(s.keys))
+	for address := range s.keys {
+		accs = append(accs, accounts.Account{Address: address})
+	}
+
+	return accs, nil
+}
+
+func (s *memorySigner) HasAddress(address ethCommon.Address) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, present := s.keys[address]
+	return present
+}
+
+func (s *memorySigner) NewAccount(passphrase string) (accounts.Account, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return accounts.Account{}, err
+	}
+
+	return s.ImportKey(key, passphrase)
+}
+
+// ImportKey adds an existing private key to the signer
+func (s *memorySigner) ImportKey(key *ecdsa.PrivateKey, passphrase string) (accounts.Account, error) {
+	account := accounts.Account{Address: crypto.PubkeyToAddress(key.PublicKey)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, present := s.keys[account.Address]; present {
+		return accounts.Account{}, ErrAccountExists
+	}
+	s.keys[account.Address] = key
+	s.passphrases[account.Address] = passphrase
+
+	return account, nil
+}
+
+func (s *memorySigner) Unlock(account accounts.Account, passphrase string, timeout time.Duration) error {
+	if _, err := s.keyWithPassphrase(account.Address, passphrase); err != nil {
+		return err
+	}
+
+	expiry := time.Time{}
+	if timeout > 0 {
+		expiry = time.Now().Add(timeout)
+	}
+
+	s.mu.Lock()
+	s.unlocked[account.Address] = expiry
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memorySigner) Lock(address ethCommon.Address) error {
+	s.mu.Lock()
+	delete(s.unlocked, address)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// key returns the key of an account without a passphrase or one that is unlocked
+func (s *memorySigner) key(address ethCommon.Address) (*ecdsa.PrivateKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, present := s.keys[address]
+	if !present {
+		return nil, ErrAccountMissing
+	}
+
+	if s.passphrases[address] == "" {
+		return key, nil
+	}
+
+	expiry, unlocked := s.unlocked[address]
+	if !unlocked || (!expiry.IsZero() && time.Now().After(expiry)) {
+		return nil, keystore.ErrLocked
+	}
+
+	return key, nil
+}
+
+func (s *memorySigner) keyWithPassphrase(address ethCommon.Address, passphrase string) (*ecdsa.PrivateKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, present := s.keys[address]
+	if !present {
+		return nil, ErrAccountMissing
+	}
+	if s.passphrases[address] != passphrase {
+		return nil, keystore.ErrDecrypt
+	}
+
+	return key, nil
+}
+
+func (s *memorySigner) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	key, err := s.key(account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if chainID != nil {
+		return types.SignTx(tx, types.NewEIP155Signer(chainID), key)
+	}
+	return types.SignTx(tx, types.HomesteadSigner{}, key)
+}
+
+func (s *memorySigner) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	key, err := s.key(account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Sign(hash, key)
+}
+
+func (s *memorySigner) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	key, err := s.keyWithPassphrase(account.Address, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Sign(hash, key)
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"strconv"
+	"math/big"
+	"strings"
+	"time"
 
+	"github.com/eximchain/go-ethereum/common/hexutil"
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,7 +17,139 @@ func makePersonalNewAccountEndpoint(svc TransactionExecutorService) endpoint.End
 	methodName := "personal_newAccount"
 	logger := log.WithField("method", methodName)
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		v, err := svc.GenerateKey(ctx)
+		req := request.([]string)
+
+		v, err := svc.PersonalNewAccount(ctx, req[0])
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalListAccountsEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_listAccounts"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.PersonalListAccounts(ctx)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalSignEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_sign"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]string)
+
+		v, err := svc.PersonalSign(ctx, req[0], req[1], req[2])
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalEcRecoverEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_ecRecover"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]string)
+
+		v, err := svc.PersonalEcRecover(ctx, req[0], req[1])
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalImportRawKeyEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_importRawKey"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]string)
+
+		v, err := svc.PersonalImportRawKey(ctx, req[0], req[1])
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalUnlockAccountEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_unlockAccount"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UnlockAccountParams)
+
+		v, err := svc.PersonalUnlockAccount(ctx, req.Address, req.Passphrase, req.Duration)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func makePersonalLockAccountEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "personal_lockAccount"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]string)
+
+		v, err := svc.PersonalLockAccount(ctx, req[0])
 
 		success := false
 		if err == nil {
@@ -37,12 +173,16 @@ func makeEthSendTransactionEndpoint(svc transactionExecutorService) endpoint.End
 
 		from := req[0].From
 		to := req[0].To
-		amount, _ := strconv.ParseInt(req[0].Value, 0, 64)
-		gasLimit, _ := strconv.ParseUint(req[0].Gas, 0, 64)
-		gasPrice, _ := strconv.ParseInt(req[0].GasPrice, 0, 64)
+		amount, gasLimit, gasPrice := req[0].quantities()
 		data := req[0].Data
 
-		txHash, err := svc.ExecuteTransaction(ctx, from, to, amount, gasLimit, gasPrice, data)
+		var txHash string
+		var err error
+		if len(req[0].PrivateFor) > 0 {
+			txHash, err = svc.ExecutePrivateTransaction(ctx, from, to, amount, gasLimit, gasPrice, data, req[0].explicitNonce(), req[0].PrivateFrom, req[0].PrivateFor)
+		} else {
+			txHash, err = svc.ExecuteTransaction(ctx, from, to, amount, gasLimit, gasPrice, data, req[0].explicitNonce())
+		}
 
 		success := false
 		if err == nil {
@@ -525,20 +665,43 @@ func makeEthSignEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
 	}
 }
 
+func makeEthSignTypedDataEndpoint(svc TransactionExecutorService, methodName string, v4 bool) endpoint.Endpoint {
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TypedDataParams)
+
+		res, err := svc.EthSignTypedData(ctx, req.Address, req.Data, v4)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
 func makeEthSignTransactionEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
 	methodName := "eth_signTransaction"
 	logger := log.WithField("method", methodName)
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(RPCTransactionParams)
+		if len(req[0].PrivateFor) > 0 {
+			return nil, invalidParams("privateFor: private transactions can only be sent")
+		}
 
 		from := req[0].From
 		to := req[0].To
-		amount, _ := strconv.ParseInt(req[0].Value, 0, 64)
-		gasLimit, _ := strconv.ParseUint(req[0].Gas, 0, 64)
-		gasPrice, _ := strconv.ParseInt(req[0].GasPrice, 0, 64)
+		amount, gasLimit, gasPrice := req[0].quantities()
 		data := req[0].Data
 
-		txHash, err := svc.EthSignTransaction(ctx, from, to, amount, gasLimit, gasPrice, data)
+		txHash, err := svc.EthSignTransaction(ctx, from, to, amount, gasLimit, gasPrice, data, req[0].explicitNonce())
 
 		success := false
 		if err == nil {
@@ -992,6 +1155,153 @@ func makeEthSubmitHashrateEndpoint(svc TransactionExecutorService) endpoint.Endp
 	}
 }
 
+func makeExecutorGetTransactionEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "executor_getTransaction"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]interface{})
+		if len(req) < 1 {
+			return nil, ErrInvalidParams
+		}
+		hash, ok := req[0].(string)
+		if !ok {
+			return nil, ErrInvalidParams
+		}
+
+		res, err := svc.GetTransaction(ctx, hash)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
+func makeExecutorListTransactionsEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "executor_listTransactions"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		filter := request.(TransactionFilter)
+
+		res, err := svc.ListTransactions(ctx, filter)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
+func makeExecutorCancelTransactionEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "executor_cancelTransaction"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]interface{})
+		if len(req) < 1 {
+			return nil, ErrInvalidParams
+		}
+		hash, ok := req[0].(string)
+		if !ok {
+			return nil, ErrInvalidParams
+		}
+
+		res, err := svc.CancelTransaction(ctx, hash)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
+func makeExecutorSpeedUpTransactionEndpoint(svc TransactionExecutorService) endpoint.Endpoint {
+	methodName := "executor_speedUpTransaction"
+	logger := log.WithField("method", methodName)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.([]interface{})
+		if len(req) < 1 {
+			return nil, ErrInvalidParams
+		}
+		hash, ok := req[0].(string)
+		if !ok {
+			return nil, ErrInvalidParams
+		}
+
+		// The gas price is optional; without it the current price is bumped
+		var gasPrice *big.Int
+		if len(req) > 1 && req[1] != nil {
+			price, ok := req[1].(string)
+			if !ok {
+				return nil, invalidParams("gasPrice: expected a hex quantity")
+			}
+			var err error
+			gasPrice, err = hexutil.DecodeBig(price)
+			if err != nil {
+				return nil, invalidParams("gasPrice: " + err.Error())
+			}
+		}
+
+		res, err := svc.SpeedUpTransaction(ctx, hash, gasPrice)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger = logger.WithFields(log.Fields{"success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
+// makePassthroughEndpoint forwards methods without a local handler to the node
+func makePassthroughEndpoint(svc TransactionExecutorService) func(context.Context, string, json.RawMessage) (json.RawMessage, error) {
+	return func(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+		res, err := svc.Passthrough(ctx, method, params)
+
+		success := false
+		if err == nil {
+			success = true
+		}
+		logger := log.WithFields(log.Fields{"method": method, "passthrough": true, "success": success, "err": err})
+		logger.Info("RPC call served")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+}
+
 func decodeRPCRequest(ctx context.Context, msg json.RawMessage) (interface{}, error) {
 	var req interface{}
 	if len(msg) == 0 {
@@ -1001,7 +1311,7 @@ func decodeRPCRequest(ctx context.Context, msg json.RawMessage) (interface{}, er
 	}
 	err := json.Unmarshal(msg, &req)
 	if err != nil {
-		return nil, err
+		return nil, invalidParams(err.Error())
 	}
 
 	return req, nil
@@ -1020,7 +1330,7 @@ func decodeRPCTransactionRequest(ctx context.Context, msg json.RawMessage) (inte
 	var req RPCTransactionParams
 	err := json.Unmarshal(msg, &req)
 	if err != nil {
-		return nil, err
+		return nil, invalidParams(err.Error())
 	}
 
 	return req, nil
@@ -1038,4 +1348,116 @@ type RPCTransaction struct {
 	Value    string `json:"value"`
 	Data     string `json:"data"`
 	Nonce    string `json:"nonce"`
+	// Quorum transaction manager public keys for private transactions
+	PrivateFrom string   `json:"privateFrom"`
+	PrivateFor  []string `json:"privateFor"`
+}
+
+// makeStringParamsDecoder decodes positional string params named by names.
+// The first required params must be given; omitted or null ones are empty.
+func makeStringParamsDecoder(required int, names ...string) jsonrpc.DecodeRequestFunc {
+	return func(_ context.Context, msg json.RawMessage) (interface{}, error) {
+		var raw []json.RawMessage
+		if len(msg) > 0 {
+			if err := json.Unmarshal(msg, &raw); err != nil {
+				return nil, invalidParams(err.Error())
+			}
+		}
+		if len(raw) < required || len(raw) > len(names) {
+			return nil, invalidParams("expected params " + strings.Join(names, ", "))
+		}
+
+		params := make([]string, len(names))
+		for i := range raw {
+			if string(raw[i]) == "null" {
+				continue
+			}
+			if err := json.Unmarshal(raw[i], &params[i]); err != nil {
+				return nil, invalidParams(names[i] + ": expected a string")
+			}
+		}
+
+		return params, nil
+	}
+}
+
+type UnlockAccountParams struct {
+	Address    string
+	Passphrase string
+	Duration   time.Duration
+}
+
+// decodeUnlockAccountRequest decodes the address, passphrase and optional
+// duration in seconds of personal_unlockAccount
+func decodeUnlockAccountRequest(ctx context.Context, msg json.RawMessage) (interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	if len(raw) < 2 || len(raw) > 3 {
+		return nil, invalidParams("expected params address, passphrase, duration")
+	}
+
+	req := UnlockAccountParams{Duration: defaultUnlockDuration}
+	if err := json.Unmarshal(raw[0], &req.Address); err != nil {
+		return nil, invalidParams("address: expected a string")
+	}
+	if err := json.Unmarshal(raw[1], &req.Passphrase); err != nil {
+		return nil, invalidParams("passphrase: expected a string")
+	}
+
+	if len(raw) == 3 && string(raw[2]) != "null" {
+		var seconds uint32
+		if err := json.Unmarshal(raw[2], &seconds); err != nil {
+			return nil, invalidParams("duration: expected a number of seconds")
+		}
+		req.Duration = time.Duration(seconds) * time.Second
+	}
+
+	return req, nil
+}
+
+type TypedDataParams struct {
+	Address string
+	Data    TypedData
+}
+
+// decodeTypedDataRequest decodes the address and typed data params of eth_signTypedData
+func decodeTypedDataRequest(ctx context.Context, msg json.RawMessage) (interface{}, error) {
+	var req []json.RawMessage
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return nil, invalidParams(err.Error())
+	}
+	if len(req) != 2 {
+		return nil, invalidParams("expected an address and typed data")
+	}
+
+	var address string
+	if err := json.Unmarshal(req[0], &address); err != nil || !isHexAddress(address) {
+		return nil, invalidParams("address: invalid address " + string(req[0]))
+	}
+
+	typed, err := decodeTypedData(req[1])
+	if err != nil {
+		return nil, invalidParams("typed data: " + err.Error())
+	}
+
+	return TypedDataParams{Address: address, Data: typed}, nil
+}
+
+// decodeTransactionFilterRequest accepts either no params or a single filter object
+func decodeTransactionFilterRequest(ctx context.Context, msg json.RawMessage) (interface{}, error) {
+	var req []TransactionFilter
+	if len(msg) > 0 {
+		err := json.Unmarshal(msg, &req)
+		if err != nil {
+			return nil, invalidParams(err.Error())
+		}
+	}
+
+	if len(req) == 0 {
+		return TransactionFilter{}, nil
+	}
+
+	return req[0], nil
 }
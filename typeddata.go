@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/common/math"
+	"github.com/eximchain/go-ethereum/crypto"
+)
+
+// TypedData is the EIP-712 structured data signed by eth_signTypedData
+type TypedData struct {
+	Types       map[string][]TypedDataField `json:"types"`
+	PrimaryType string                      `json:"primaryType"`
+	Domain      map[string]interface{}      `json:"domain"`
+	Message     map[string]interface{}      `json:"message"`
+}
+
+// TypedDataField is one member of an EIP-712 struct type
+type TypedDataField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+const typedDataDomain = "EIP712Domain"
+
+var (
+	typedDataArray   = regexp.MustCompile(`^(.+)\[([0-9]*)\]$`)
+	typedDataInteger = regexp.MustCompile(`^(u?)int([0-9]*)$`)
+	typedDataBytes   = regexp.MustCompile(`^bytes([0-9]+)$`)
+)
+
+// decodeTypedData accepts typed data as a JSON object or, as MetaMask sends
+// it, a string holding one. Numbers are kept exact.
+func decodeTypedData(msg json.RawMessage) (TypedData, error) {
+	var typed TypedData
+
+	var s string
+	if err := json.Unmarshal(msg, &s); err == nil {
+		msg = json.RawMessage(s)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	err := dec.Decode(&typed)
+	return typed, err
+}
+
+// hash returns the EIP-712 digest to sign. Arrays are only encoded if v4 is set,
+// as in eth_signTypedData_v4.
+func (typed TypedData) hash(v4 bool) ([]byte, error) {
+	if _, ok := typed.Types[typedDataDomain]; !ok {
+		return nil, fmt.Errorf("missing %s type", typedDataDomain)
+	}
+	if _, ok := typed.Types[typed.PrimaryType]; !ok {
+		return nil, fmt.Errorf("unknown primary type %q", typed.PrimaryType)
+	}
+
+	domainSeparator, err := typed.hashStruct(typedDataDomain, typed.Domain, v4)
+	if err != nil {
+		return nil, fmt.Errorf("domain: %s", err)
+	}
+
+	messageHash, err := typed.hashStruct(typed.PrimaryType, typed.Message, v4)
+	if err != nil {
+		return nil, fmt.Errorf("message: %s", err)
+	}
+
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, messageHash), nil
+}
+
+func (typed TypedData) hashStruct(name string, data map[string]interface{}, v4 bool) ([]byte, error) {
+	encoded, err := typed.encodeData(name, data, v4)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Keccak256(encoded), nil
+}
+
+// encodeType returns the type's signature followed by those of the struct
+// types it references, in alphabetical order
+func (typed TypedData) encodeType(name string) string {
+	deps := map[string]bool{}
+	typed.dependencies(name, deps)
+	delete(deps, name)
+
+	sorted := make([]string, 0, len(deps))
+	for dep := range deps {
+		sorted = append(sorted, dep)
+	}
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	for _, t := range append([]string{name}, sorted...) {
+		fields := make([]string, len(typed.Types[t]))
+		for i, field := range typed.Types[t] {
+			fields[i] = field.Type + " " + field.Name
+		}
+		b.WriteString(t + "(" + strings.Join(fields, ",") + ")")
+	}
+
+	return b.String()
+}
+
+func (typed TypedData) dependencies(name string, deps map[string]bool) {
+	if deps[name] {
+		return
+	}
+	if _, ok := typed.Types[name]; !ok {
+		return
+	}
+
+	deps[name] = true
+	for _, field := range typed.Types[name] {
+		typed.dependencies(baseType(field.Type), deps)
+	}
+}
+
+// baseType strips array suffixes from a type
+func baseType(t string) string {
+	for {
+		m := typedDataArray.FindStringSubmatch(t)
+		if m == nil {
+			return t
+		}
+		t = m[1]
+	}
+}
+
+func (typed TypedData) encodeData(name string, data map[string]interface{}, v4 bool) ([]byte, error) {
+	encoded := crypto.Keccak256([]byte(typed.encodeType(name)))
+
+	for _, field := range typed.Types[name] {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing value for %s", field.Name)
+		}
+
+		word, err := typed.encodeValue(field.Type, value, v4)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", field.Name, err)
+		}
+		encoded = append(encoded, word...)
+	}
+
+	return encoded, nil
+}
+
+// encodeValue returns the 32 byte encoding of a value of the given type
+func (typed TypedData) encodeValue(t string, value interface{}, v4 bool) ([]byte, error) {
+	if m := typedDataArray.FindStringSubmatch(t); m != nil {
+		if !v4 {
+			return nil, fmt.Errorf("arrays need eth_signTypedData_v4")
+		}
+
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an array for %s", t)
+		}
+		if m[2] != "" && strconv.Itoa(len(items)) != m[2] {
+			return nil, fmt.Errorf("expected %s items for %s, got %d", m[2], t, len(items))
+		}
+
+		var encoded []byte
+		for _, item := range items {
+			word, err := typed.encodeValue(m[1], item, v4)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, word...)
+		}
+		return crypto.Keccak256(encoded), nil
+	}
+
+	if _, ok := typed.Types[t]; ok {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object for %s", t)
+		}
+		return typed.hashStruct(t, data, v4)
+	}
+
+	switch t {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+		return crypto.Keccak256([]byte(s)), nil
+	case "bytes":
+		b, err := typedDataBytesValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(b), nil
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean")
+		}
+		word := make([]byte, 32)
+		if b {
+			word[31] = 1
+		}
+		return word, nil
+	case "address":
+		s, ok := value.(string)
+		if !ok || !isHexAddress(s) {
+			return nil, fmt.Errorf("invalid address %v", value)
+		}
+		return ethCommon.LeftPadBytes(ethCommon.HexToAddress(s).Bytes(), 32), nil
+	}
+
+	if m := typedDataBytes.FindStringSubmatch(t); m != nil {
+		size, _ := strconv.Atoi(m[1])
+		b, err := typedDataBytesValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 1 || size > 32 || len(b) != size {
+			return nil, fmt.Errorf("expected %d bytes for %s", size, t)
+		}
+		return ethCommon.RightPadBytes(b, 32), nil
+	}
+
+	if m := typedDataInteger.FindStringSubmatch(t); m != nil {
+		return encodeTypedDataInteger(m[1] == "u", m[2], value)
+	}
+
+	return nil, fmt.Errorf("unknown type %q", t)
+}
+
+func typedDataBytesValue(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected hex bytes")
+	}
+
+	return hexutil.Decode(s)
+}
+
+// encodeTypedDataInteger accepts JSON numbers and decimal or hex strings
+func encodeTypedDataInteger(unsigned bool, bits string, value interface{}) ([]byte, error) {
+	size := 256
+	if bits != "" {
+		size, _ = strconv.Atoi(bits)
+	}
+	if size < 8 || size > 256 || size%8 != 0 {
+		return nil, fmt.Errorf("invalid integer size %s", bits)
+	}
+
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return nil, fmt.Errorf("expected an integer")
+	}
+
+	base := 10
+	if has0xPrefix(s) {
+		s, base = s[2:], 16
+	}
+
+	n, ok := new(big.Int).SetString(s, base)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %s", s)
+	}
+
+	if unsigned {
+		if n.Sign() < 0 || n.BitLen() > size {
+			return nil, fmt.Errorf("%s does not fit in uint%d", s, size)
+		}
+	} else {
+		limit := new(big.Int).Lsh(big.NewInt(1), uint(size-1))
+		if n.Cmp(limit) >= 0 || n.Cmp(new(big.Int).Neg(limit)) < 0 {
+			return nil, fmt.Errorf("%s does not fit in int%d", s, size)
+		}
+	}
+
+	return math.PaddedBigBytes(math.U256(n), 32), nil
+}
+
+// EthSignTypedData signs the EIP-712 digest of typed data, returning the
+// signature in the same form as EthSign
+func (svc transactionExecutorService) EthSignTypedData(ctx context.Context, address string, typed TypedData, v4 bool) (interface{}, error) {
+	account, err := svc.userAccount(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := typed.hash(v4)
+	if err != nil {
+		return nil, invalidParams("typed data: " + err.Error())
+	}
+
+	signature, err := svc.signer.SignHash(account, hash)
+	if err != nil {
+		return nil, signerError(err)
+	}
+
+	signature[64] += 27
+
+	return ethCommon.ToHex(signature), nil
+}
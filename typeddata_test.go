@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/crypto"
+)
+
+// The example from EIP-712
+const testTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+func TestTypedDataHash(t *testing.T) {
+	typed, err := decodeTypedData(json.RawMessage(testTypedData))
+	if err != nil {
+		t.Fatalf("cannot decode typed data %s", err)
+	}
+
+	if encoded := typed.encodeType("Mail"); encoded != "Mail(Person from,Person to,string contents)Person(string name,address wallet)" {
+		t.Fatalf("unexpected type encoding %s", encoded)
+	}
+
+	domainSeparator, _ := typed.hashStruct(typedDataDomain, typed.Domain, false)
+	if ethCommon.ToHex(domainSeparator) != "0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f" {
+		t.Fatalf("unexpected domain separator %x", domainSeparator)
+	}
+
+	for _, v4 := range []bool{false, true} {
+		hash, err := typed.hash(v4)
+		if err != nil || ethCommon.ToHex(hash) != "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+			t.Fatalf("unexpected hash %x %v", hash, err)
+		}
+	}
+
+	// Arrays are only supported by v4
+	typed.Types["Mail"][1].Type = "Person[]"
+	typed.Message["to"] = []interface{}{typed.Message["to"], typed.Message["from"]}
+	if _, err := typed.hash(false); err == nil || !strings.Contains(err.Error(), "v4") {
+		t.Fatalf("expected arrays to need v4, got %v", err)
+	}
+
+	hash, err := typed.hash(true)
+	if err != nil {
+		t.Fatalf("cannot hash typed data with arrays %s", err)
+	}
+	bob, _ := typed.hashStruct("Person", typed.Message["to"].([]interface{})[0].(map[string]interface{}), true)
+	cow, _ := typed.hashStruct("Person", typed.Message["from"].(map[string]interface{}), true)
+	from, _ := typed.encodeValue("Person", typed.Message["from"], true)
+	contents, _ := typed.encodeValue("string", typed.Message["contents"], true)
+	mail := crypto.Keccak256(crypto.Keccak256([]byte(typed.encodeType("Mail"))), from, crypto.Keccak256(bob, cow), contents)
+	expected := crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, mail)
+	if ethCommon.ToHex(hash) != ethCommon.ToHex(expected) {
+		t.Fatalf("expected hash %x, got %x", expected, hash)
+	}
+}
+
+func TestTypedDataValues(t *testing.T) {
+	typed := TypedData{Types: map[string][]TypedDataField{}}
+
+	for _, c := range []struct {
+		t        string
+		value    interface{}
+		expected string
+	}{
+		{"uint8", json.Number("255"), "0x00000000000000000000000000000000000000000000000000000000000000ff"},
+		{"uint256", "0x10", "0x0000000000000000000000000000000000000000000000000000000000000010"},
+		{"int8", json.Number("-1"), "0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{"bool", true, "0x0000000000000000000000000000000000000000000000000000000000000001"},
+		{"bytes4", "0x12345678", "0x1234567800000000000000000000000000000000000000000000000000000000"},
+		{"uint8", json.Number("256"), ""},
+		{"int8", json.Number("128"), ""},
+		{"uint256", json.Number("-1"), ""},
+		{"uint7", json.Number("1"), ""},
+		{"bytes4", "0x1234", ""},
+		{"address", "0x1234", ""},
+		{"bool", "true", ""},
+		{"Unknown", "x", ""},
+	} {
+		word, err := typed.encodeValue(c.t, c.value, true)
+		if c.expected == "" {
+			if err == nil {
+				t.Fatalf("expected error for %s %v", c.t, c.value)
+			}
+			continue
+		}
+		if err != nil || ethCommon.ToHex(word) != c.expected {
+			t.Fatalf("unexpected encoding of %s %v: %x %v", c.t, c.value, word, err)
+		}
+	}
+}
+
+func TestSignTypedData(t *testing.T) {
+	svc, _ := NewTestService()
+	key := crypto.Keccak256([]byte("cow"))
+	privateKey, err := crypto.ToECDSA(key)
+	if err != nil {
+		t.Fatalf("cannot load key %s", err)
+	}
+	svc.signer.ImportKey(privateKey, "")
+
+	srv := httptest.NewServer(MakeRPCHandler(svc, 2))
+	defer srv.Close()
+
+	// MetaMask sends the typed data as a string
+	encoded, _ := json.Marshal(testTypedData)
+	var res testRPCResponse
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_signTypedData_v4","params":["0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826",`+string(encoded)+`]}`), &res)
+	if res.Error != nil || string(res.Result) != `"0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"` {
+		t.Fatalf("unexpected signature %s %+v", res.Result, res.Error)
+	}
+
+	res = testRPCResponse{}
+	json.Unmarshal(postRPC(t, srv.URL, `{"jsonrpc":"2.0","id":2,"method":"eth_signTypedData_v3","params":["0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826",{"types":{"EIP712Domain":[]},"primaryType":"Mail"}]}`), &res)
+	if res.Error == nil || res.Error.Code != -32602 || !strings.Contains(res.Error.Message, "primary type") {
+		t.Fatalf("expected invalid params, got %+v", res)
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const executorSocket = "/tmp/executor.sock"
@@ -20,6 +23,28 @@ type UserCommand struct {
 	delete bool
 	update bool
 	list   bool
+	grant  string
+	revoke string
+	role   string
+	// Comma-separated method patterns allowed in addition to the role's
+	methods     string
+	token       string
+	addToken    string
+	revokeToken string
+	expires     time.Duration
+	// Limits given on the command line, by flag name
+	limits map[string]string
+	// A policy as JSON, or none to remove it, for the account if set or
+	// else the user
+	policy       string
+	account      string
+	listPolicies bool
+}
+
+// ipcReply is the server's answer to a user command sent over IPC
+type ipcReply struct {
+	Output string
+	Failed bool
 }
 
 func ipcServer(db *BoltDB, c net.Conn) {
@@ -31,10 +56,26 @@ func ipcServer(db *BoltDB, c net.Conn) {
 		log.Println("decode error", err)
 		return
 	}
-	log.Println(args)
-	// Echo the reply to the server and the client
-	writer := io.MultiWriter(c, os.Stdout)
-	runUserCommand(db, writer, args)
+	// Values may hold tokens or policies, so only the flags are logged, and
+	// the reply goes to the client alone
+	log.Println("user command", commandFlags(args))
+	var out bytes.Buffer
+	ok := runUserCommand(db, &out, args)
+	if err := gob.NewEncoder(c).Encode(ipcReply{Output: out.String(), Failed: !ok}); err != nil {
+		log.Println("encode error", err)
+	}
+}
+
+// commandFlags returns the names of the flags in a user command's arguments
+func commandFlags(args []string) []string {
+	flags := []string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			flags = append(flags, strings.SplitN(arg, "=", 2)[0])
+		}
+	}
+
+	return flags
 }
 
 func acceptLoop(db *BoltDB, l net.Listener) {
@@ -67,25 +108,26 @@ func listenIPC(db *BoltDB) net.Listener {
 	return l
 }
 
-func sendIPC(args []string) string {
+func sendIPC(args []string) ipcReply {
 	c, err := net.Dial("unix", executorSocket)
 	if err != nil {
 		log.Println("dial error", err)
-		return ""
+		return ipcReply{Failed: true}
 	}
 	defer c.Close()
 	enc := gob.NewEncoder(c)
 	err = enc.Encode(args)
 	if err != nil {
 		log.Println("encode error", err)
-		return ""
+		return ipcReply{Failed: true}
 	}
-	data, err := ioutil.ReadAll(c)
+	var reply ipcReply
+	err = gob.NewDecoder(c).Decode(&reply)
 	if err != nil {
 		log.Println("read error", err)
-		return ""
+		return ipcReply{Failed: true}
 	}
-	return string(data)
+	return reply
 }
 
 func openUserDB() (*BoltDB, error) {
@@ -99,86 +141,300 @@ func RunUserCommand(args []string) {
 	if err != nil {
 		// If the open timed out, the server is likely running; send over IPC
 		if err.Error() == "timeout" {
-			fmt.Print(sendIPC(args))
+			reply := sendIPC(args)
+			fmt.Print(reply.Output)
+			if reply.Failed {
+				os.Exit(1)
+			}
 			return
 		}
 		log.Println("open database error", err)
-		return
+		os.Exit(1)
 	}
 
-	runUserCommand(db, os.Stdout, args)
+	if !runUserCommand(db, os.Stdout, args) {
+		os.Exit(1)
+	}
+}
+
+// commandFailed reports an error to the user command's client
+func commandFailed(out io.Writer, action string, err error) bool {
+	fmt.Fprintln(out, "cannot "+action+":", err)
+	return false
 }
 
-func runUserCommand(db *BoltDB, out io.Writer, args []string) {
-	userCommand := flag.NewFlagSet("user", flag.ExitOnError)
+// runUserCommand runs a user command, writing its output to out, and reports
+// whether it succeeded
+func runUserCommand(db *BoltDB, out io.Writer, args []string) bool {
+	// A malformed flag sent over IPC must not exit the server
+	userCommand := flag.NewFlagSet("user", flag.ContinueOnError)
+	userCommand.SetOutput(out)
 	emailFlag := userCommand.String("email", "", "user email")
 	deleteFlag := userCommand.Bool("delete", false, "delete user by email")
 	updateFlag := userCommand.Bool("update", false, "update user token")
 	listFlag := userCommand.Bool("list", false, "list all users")
-	userCommand.Parse(args)
+	grantFlag := userCommand.String("grant", "", "allow the user to sign with an account address")
+	revokeFlag := userCommand.String("revoke", "", "stop the user signing with an account address")
+	roleFlag := userCommand.String("role", "", "set the user role: read-only, signer, admin or custom; with -update, the role of a new user, signer by default")
+	methodsFlag := userCommand.String("methods", "", "comma-separated methods the user may call in addition to the role's, set with -role")
+	tokenFlag := userCommand.String("token", defaultTokenName, "name of the token replaced by -update")
+	addTokenFlag := userCommand.String("add-token", "", "give the user another token with this name")
+	revokeTokenFlag := userCommand.String("revoke-token", "", "revoke the user's token with this name")
+	expiresFlag := userCommand.Duration("expires", 0, "expire the token added with -add-token after this duration, e.g. 720h")
+	userCommand.Float64("read-rate", 0, "set the read requests per second the user may make, 0 for unlimited")
+	userCommand.Float64("sign-rate", 0, "set the signing and admin requests per second the user may make, 0 for unlimited")
+	userCommand.Uint64("tx-quota", 0, "set the transactions the user may send per UTC day, 0 for unlimited")
+	policyFlag := userCommand.String("policy", "", "set the transaction policy of the user, or of -account, as JSON; none removes it")
+	accountFlag := userCommand.String("account", "", "account address to set the policy of instead of a user")
+	listPoliciesFlag := userCommand.Bool("list-policies", false, "list all transaction policies")
+	if err := userCommand.Parse(args); err != nil {
+		return false
+	}
+
+	limits := map[string]string{}
+	userCommand.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "read-rate", "sign-rate", "tx-quota":
+			limits[f.Name] = f.Value.String()
+		}
+	})
 
-	command := UserCommand{email: *emailFlag, delete: *deleteFlag, update: *updateFlag, list: *listFlag}
+	command := UserCommand{
+		email:       *emailFlag,
+		delete:      *deleteFlag,
+		update:      *updateFlag,
+		list:        *listFlag,
+		grant:       *grantFlag,
+		revoke:      *revokeFlag,
+		role:        *roleFlag,
+		methods:     *methodsFlag,
+		token:       *tokenFlag,
+		addToken:    *addTokenFlag,
+		revokeToken: *revokeTokenFlag,
+		expires:     *expiresFlag,
+		limits:      limits,
+
+		policy:       *policyFlag,
+		account:      *accountFlag,
+		listPolicies: *listPoliciesFlag,
+	}
 
 	if command.list {
-		err := db.listUsers(out)
-		if err != nil {
-			log.Println("ListUsers", err)
+		if err := db.listUsers(out); err != nil {
+			return commandFailed(out, "list users", err)
 		}
-		return
+		return true
+	}
+
+	if command.listPolicies {
+		if err := db.listPolicies(out); err != nil {
+			return commandFailed(out, "list policies", err)
+		}
+		return true
+	}
+
+	if command.policy != "" && command.account != "" {
+		if !isHexAddress(command.account) {
+			fmt.Fprintln(out, "invalid account address "+command.account)
+			return false
+		}
+
+		return setPolicy(db, out, accountPolicyScope(command.account), command.policy)
 	}
 
 	if len(command.email) == 0 {
 		fmt.Fprintln(out, "user email is empty")
-		return
+		return false
+	}
+
+	if command.policy != "" {
+		return setPolicy(db, out, userPolicyScope(command.email), command.policy)
+	}
+
+	if command.role != "" && !isRole(command.role) {
+		fmt.Fprintln(out, "invalid role "+command.role)
+		return false
 	}
 
-	if command.delete {
-		token, err := db.getTokenByEmail(command.email)
+	if command.role != "" && !command.update {
+		methods := splitMethodList(command.methods)
+		err := db.setUserRole(command.email, command.role, methods)
 		if err != nil {
-			log.Println("GetTokenByEmail", err)
+			fmt.Fprintln(out, err)
+			return false
 		}
 
-		if token != "" {
-			err := db.deleteUserByToken(token)
-			if err != nil {
-				if err != nil {
-					log.Println("DeleteUserByToken error", err)
+		fmt.Fprintln(out, command.email, command.role, strings.Join(methods, ","))
+	} else if len(command.limits) > 0 {
+		var user User
+		err := db.updateUser(command.email, func(u *User) {
+			for name, value := range command.limits {
+				switch name {
+				case "read-rate":
+					u.ReadRate, _ = strconv.ParseFloat(value, 64)
+				case "sign-rate":
+					u.SignRate, _ = strconv.ParseFloat(value, 64)
+				case "tx-quota":
+					u.DailyTxQuota, _ = strconv.ParseUint(value, 10, 64)
 				}
 			}
+			user = *u
+		})
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
 
-			fmt.Fprintln(out, command.email+" deleted")
-		} else {
+		fmt.Fprintln(out, command.email, formatLimits(user))
+	} else if command.grant != "" {
+		if !isHexAddress(command.grant) {
+			fmt.Fprintln(out, "invalid account address "+command.grant)
+			return false
+		}
+
+		user, err := db.getUserByEmail(command.email)
+		if err != nil {
+			return commandFailed(out, "read user", err)
+		}
+		if user.Email == "" {
+			fmt.Fprintln(out, command.email+" not found")
+			return false
+		}
+
+		err = db.addAccountUser(command.grant, command.email)
+		if err != nil {
+			return commandFailed(out, "grant account", err)
+		}
+
+		fmt.Fprintln(out, command.email+" granted "+strings.ToLower(command.grant))
+	} else if command.revoke != "" {
+		removed, err := db.removeAccountUser(command.revoke, command.email)
+		if err != nil {
+			return commandFailed(out, "revoke account", err)
+		}
+
+		if !removed {
+			fmt.Fprintln(out, command.email+" cannot use "+strings.ToLower(command.revoke))
+			return false
+		}
+		fmt.Fprintln(out, command.email+" revoked "+strings.ToLower(command.revoke))
+	} else if command.addToken != "" {
+		// Only the token's hash is kept, so this is the only time it is shown
+		token, err := db.addToken(command.email, command.addToken, command.expires)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+
+		fmt.Fprintln(out, command.email, command.addToken, token)
+	} else if command.revokeToken != "" {
+		revoked, err := db.revokeToken(command.email, command.revokeToken)
+		if err != nil {
+			return commandFailed(out, "revoke token", err)
+		}
+
+		if !revoked {
+			fmt.Fprintln(out, "token not found")
+			return false
+		}
+		fmt.Fprintln(out, command.email+" token "+command.revokeToken+" revoked")
+	} else if command.delete {
+		deleted, err := db.deleteUser(command.email)
+		if err != nil {
+			return commandFailed(out, "delete user", err)
+		}
+
+		if !deleted {
 			fmt.Fprintln(out, "user not found")
+			return false
 		}
+		fmt.Fprintln(out, command.email+" deleted")
 	} else if command.update {
-		token, err := db.getTokenByEmail(command.email)
+		user, err := db.getUserByEmail(command.email)
 		if err != nil {
-			log.Println("GetTokenByEmail", err)
+			return commandFailed(out, "read user", err)
 		}
 
-		if token != "" {
-			err = db.deleteUserByToken(token)
+		// Replace the named token of an existing user, keeping their role
+		// unless -role is given, or create a user with -role or as a signer.
+		// Only the token's hash is kept, so this is the only time it is shown.
+		methods := splitMethodList(command.methods)
+		var token string
+		if user.Email != "" {
+			if command.role != "" {
+				if err := db.setUserRole(command.email, command.role, methods); err != nil {
+					fmt.Fprintln(out, err)
+					return false
+				}
+			}
+			token, err = db.rotateToken(command.email, command.token)
 			if err != nil {
-				log.Println("DeleteUserByToken error", err)
+				return commandFailed(out, "replace token", err)
+			}
+		} else {
+			role := command.role
+			if role == "" {
+				role = RoleSigner
+			}
+			token, err = db.createUser(command.email, role, methods)
+			if err != nil {
+				return commandFailed(out, "create user", err)
 			}
-		}
-
-		token, err = db.createUser(command.email)
-		if err != nil {
-			log.Println("CreateUser", err)
 		}
 
 		fmt.Fprintln(out, command.email, token)
 	} else {
-		token, err := db.getTokenByEmail(command.email)
+		user, err := db.getUserByEmail(command.email)
 		if err != nil {
-			log.Println("GetTokenByEmail", err)
+			return commandFailed(out, "read user", err)
 		}
 
-		if token == "" {
+		if user.Email == "" {
 			fmt.Fprintln(out, command.email+" not found")
+			return false
+		}
+		if err := db.writeUsers(out, command.email); err != nil {
+			return commandFailed(out, "show user", err)
+		}
+	}
+
+	return true
+}
+
+// setPolicy stores a policy given as JSON, or removes it if it is none, and
+// reports whether it succeeded
+func setPolicy(db *BoltDB, out io.Writer, scope string, policyJSON string) bool {
+	if policyJSON == "none" {
+		deleted, err := db.deletePolicy(scope)
+		if err != nil {
+			return commandFailed(out, "remove policy", err)
+		}
+
+		if deleted {
+			fmt.Fprintln(out, scope+" policy removed")
 		} else {
-			fmt.Fprintln(out, command.email, token)
+			fmt.Fprintln(out, scope+" has no policy")
 		}
+		return true
+	}
+
+	var policy Policy
+	dec := json.NewDecoder(strings.NewReader(policyJSON))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		fmt.Fprintln(out, "invalid policy:", err)
+		return false
+	}
+	if err := policy.validate(); err != nil {
+		fmt.Fprintln(out, "invalid policy:", err)
+		return false
 	}
+
+	if err := db.putPolicy(scope, policy); err != nil {
+		return commandFailed(out, "set policy", err)
+	}
+
+	v, _ := json.Marshal(policy)
+	fmt.Fprintln(out, scope, string(v))
+	return true
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+// makeRPCTransactionDecoder decodes and validates the transaction object of
+// eth_sendTransaction and eth_signTransaction
+func makeRPCTransactionDecoder(signer Signer) jsonrpc.DecodeRequestFunc {
+	return func(ctx context.Context, msg json.RawMessage) (interface{}, error) {
+		req, err := decodeRPCTransactionRequest(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		params := req.(RPCTransactionParams)
+		if len(params) < 1 {
+			return nil, invalidParams("expected a transaction object")
+		}
+
+		if err := params[0].validate(signer); err != nil {
+			return nil, err
+		}
+
+		return params, nil
+	}
+}
+
+// validate checks that quantities are hex encoded, addresses are well formed
+// and the sender is an account held by signer. Errors name the bad field.
+func (tx RPCTransaction) validate(signer Signer) error {
+	if tx.From == "" {
+		return invalidParams("from: missing sender address")
+	}
+	if !isHexAddress(tx.From) {
+		return invalidParams("from: invalid address " + tx.From)
+	}
+
+	if tx.To != "" && !isHexAddress(tx.To) {
+		return invalidParams("to: invalid address " + tx.To)
+	}
+
+	if tx.Data != "" {
+		if _, err := hexutil.Decode(tx.Data); err != nil {
+			return invalidParams("data: " + err.Error())
+		}
+	}
+
+	if tx.To == "" && len(ethCommon.FromHex(tx.Data)) == 0 {
+		return invalidParams("to: missing recipient, and no data for a contract creation")
+	}
+
+	for _, q := range []struct {
+		field string
+		value string
+	}{
+		{"gas", tx.Gas},
+		{"nonce", tx.Nonce},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if _, err := hexutil.DecodeUint64(q.value); err != nil {
+			return invalidParams(q.field + ": " + err.Error())
+		}
+	}
+
+	for _, q := range []struct {
+		field string
+		value string
+	}{
+		{"gasPrice", tx.GasPrice},
+		{"value", tx.Value},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if _, err := hexutil.DecodeBig(q.value); err != nil {
+			return invalidParams(q.field + ": " + err.Error())
+		}
+	}
+
+	if err := tx.validatePrivacy(); err != nil {
+		return err
+	}
+
+	if !signer.HasAddress(ethCommon.HexToAddress(tx.From)) {
+		return invalidParams("from: account " + tx.From + " is not held by the executor")
+	}
+
+	return nil
+}
+
+// validatePrivacy checks the Quorum private transaction fields, which hold
+// base64 transaction manager public keys
+func (tx RPCTransaction) validatePrivacy() error {
+	if len(tx.PrivateFor) == 0 {
+		if tx.PrivateFrom != "" {
+			return invalidParams("privateFrom: set without privateFor")
+		}
+		return nil
+	}
+
+	if len(ethCommon.FromHex(tx.Data)) == 0 {
+		return invalidParams("data: private transactions need a payload")
+	}
+
+	if tx.PrivateFrom != "" && !isPublicKey(tx.PrivateFrom) {
+		return invalidParams("privateFrom: invalid public key " + tx.PrivateFrom)
+	}
+	for _, key := range tx.PrivateFor {
+		if !isPublicKey(key) {
+			return invalidParams("privateFor: invalid public key " + key)
+		}
+	}
+
+	return nil
+}
+
+// quantities returns the parsed value, gas and gas price of a validated
+// transaction. An omitted gas price is nil, other omitted fields are zero.
+func (tx RPCTransaction) quantities() (amount *big.Int, gasLimit uint64, gasPrice *big.Int) {
+	if tx.GasPrice != "" {
+		gasPrice = parseQuantity(tx.GasPrice)
+	}
+
+	return parseQuantity(tx.Value), parseQuantity(tx.Gas).Uint64(), gasPrice
+}
+
+// explicitNonce returns the nonce of a validated transaction, or nil if the
+// executor should pick one
+func (tx RPCTransaction) explicitNonce() *uint64 {
+	if tx.Nonce == "" {
+		return nil
+	}
+
+	nonce := parseQuantity(tx.Nonce).Uint64()
+	return &nonce
+}
+
+func parseQuantity(s string) *big.Int {
+	if s == "" {
+		return new(big.Int)
+	}
+
+	n, err := hexutil.DecodeBig(s)
+	if err != nil {
+		return new(big.Int)
+	}
+
+	return n
+}
+
+// isPublicKey checks for a base64 encoded 32 byte transaction manager key
+func isPublicKey(s string) bool {
+	key, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(key) == 32
+}
+
+// isHexAddress is stricter than ethCommon.IsHexAddress in requiring the 0x prefix
+func isHexAddress(s string) bool {
+	return has0xPrefix(s) && ethCommon.IsHexAddress(s)
+}
+
+func has0xPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestValidateRPCTransaction(t *testing.T) {
+	svc, _ := NewTestService()
+	from, err := svc.GenerateKey(context.Background())
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+	to := "0x0000000000000000000000000000000000000001"
+
+	for _, c := range []struct {
+		tx    RPCTransaction
+		field string
+	}{
+		{RPCTransaction{From: from, To: to, Value: "0x1", Gas: "0x5208", GasPrice: "0x0"}, ""},
+		{RPCTransaction{From: from, Data: "0x6060"}, ""},
+		{RPCTransaction{From: from, To: to, Value: "0x56bc75e2d63100000", GasPrice: "0x10000000000000000"}, ""},
+		{RPCTransaction{To: to}, "from"},
+		{RPCTransaction{From: "0x1234", To: to}, "from"},
+		{RPCTransaction{From: strings.TrimPrefix(from, "0x"), To: to}, "from"},
+		{RPCTransaction{From: "0x0000000000000000000000000000000000000002", To: to}, "from"},
+		{RPCTransaction{From: from, To: "0xnope"}, "to"},
+		{RPCTransaction{From: from}, "to"},
+		{RPCTransaction{From: from, Data: "0x"}, "to"},
+		{RPCTransaction{From: from, To: to, Data: "6060"}, "data"},
+		{RPCTransaction{From: from, To: to, Value: "10"}, "value"},
+		{RPCTransaction{From: from, To: to, Value: "0xzz"}, "value"},
+		{RPCTransaction{From: from, To: to, GasPrice: "0x1" + strings.Repeat("0", 64)}, "gasPrice"},
+		{RPCTransaction{From: from, To: to, Gas: "0x"}, "gas"},
+		{RPCTransaction{From: from, To: to, Nonce: "0x01"}, "nonce"},
+		{RPCTransaction{From: from, To: to, Data: "0x6060", PrivateFor: []string{testPrivateFor}}, ""},
+		{RPCTransaction{From: from, To: to, PrivateFor: []string{testPrivateFor}}, "data"},
+		{RPCTransaction{From: from, To: to, Data: "0x6060", PrivateFor: []string{"0x1234"}}, "privateFor"},
+		{RPCTransaction{From: from, To: to, Data: "0x6060", PrivateFrom: "AQ==", PrivateFor: []string{testPrivateFor}}, "privateFrom"},
+		{RPCTransaction{From: from, To: to, PrivateFrom: testPrivateFrom}, "privateFrom"},
+	} {
+		err := c.tx.validate(svc.signer)
+		if c.field == "" {
+			if err != nil {
+				t.Fatalf("unexpected error for %+v: %s", c.tx, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.HasPrefix(err.Error(), "invalid params: "+c.field+":") || rpcError(err).Code != -32602 {
+			t.Fatalf("expected invalid %s for %+v, got %v", c.field, c.tx, err)
+		}
+	}
+}
+
+func TestLargeQuantities(t *testing.T) {
+	svc, q := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	// 100 ether does not fit in an int64 of wei
+	tx := RPCTransaction{From: from, To: "0x0000000000000000000000000000000000000001", Value: "0x56bc75e2d63100000", Gas: "0x5208", GasPrice: "0x10000000000000000"}
+	amount, gasLimit, gasPrice := tx.quantities()
+	if _, err := svc.ExecuteTransaction(ctx, from, tx.To, amount, gasLimit, gasPrice, "", nil); err != nil {
+		t.Fatalf("cannot execute transaction %s", err)
+	}
+
+	if q.sent[0].Value().String() != "100000000000000000000" || q.sent[0].GasPrice().String() != "18446744073709551616" {
+		t.Fatalf("unexpected value %s gas price %s", q.sent[0].Value(), q.sent[0].GasPrice())
+	}
+}
+
+func TestContractCreation(t *testing.T) {
+	svc, q := NewTestService()
+	ctx := context.Background()
+
+	from, err := svc.GenerateKey(ctx)
+	if err != nil {
+		t.Fatalf("cannot generate key %s", err)
+	}
+
+	if _, err := svc.ExecuteTransaction(ctx, from, "", big.NewInt(0), 100000, big.NewInt(0), "0x6060", nil); err != nil {
+		t.Fatalf("cannot create contract %s", err)
+	}
+
+	if q.sent[0].To() != nil {
+		t.Fatalf("expected contract creation, got recipient %s", q.sent[0].To().Hex())
+	}
+}
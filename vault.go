@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/eximchain/go-ethereum/accounts"
+	"github.com/eximchain/go-ethereum/accounts/keystore"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/eximchain/go-ethereum/crypto"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// vaultKeyStore keeps one vault secret per account, stored under
+// <path>/<lowercase hex address>. A secret holds either the raw private key
+// ("key") or the passphrase of a geth keystore file ("passphrase").
+type vaultKeyStore struct {
+	client *vault.Client
+	path   string
+
+	// When set, new accounts are created in this keystore and only their
+	// passphrase is written to vault.
+	keystore *keystore.KeyStore
+}
+
+func newVaultKeyStore(client *vault.Client, keyPath string, ks *keystore.KeyStore) *vaultKeyStore {
+	return &vaultKeyStore{
+		client:   client,
+		path:     strings.Trim(keyPath, "/"),
+		keystore: ks,
+	}
+}
+
+func (vks *vaultKeyStore) keyPath(address ethCommon.Address) string {
+	return path.Join(vks.path, strings.ToLower(address.Hex()))
+}
+
+// NewAccount generates a new key and stores it in vault. Vault's own access
+// control protects the key, so a passphrase is rejected rather than ignored.
+func (vks *vaultKeyStore) NewAccount(passphrase string) (accounts.Account, error) {
+	if passphrase != "" {
+		return accounts.Account{}, ErrPassphraseUnsupported
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return accounts.Account{}, err
+	}
+
+	return vks.ImportKey(key, "")
+}
+
+// ImportKey stores an existing key in vault, or in the keystore with a
+// random passphrase kept in vault. The caller cannot choose a passphrase.
+func (vks *vaultKeyStore) ImportKey(key *ecdsa.PrivateKey, passphrase string) (accounts.Account, error) {
+	if passphrase != "" {
+		return accounts.Account{}, ErrPassphraseUnsupported
+	}
+
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	if vks.HasAddress(address) {
+		return accounts.Account{}, ErrAccountExists
+	}
+
+	if vks.keystore != nil {
+		passphrase, err := createPassphrase()
+		if err != nil {
+			return accounts.Account{}, err
+		}
+
+		account, err := vks.keystore.ImportECDSA(key, passphrase)
+		if err != nil {
+			return accounts.Account{}, err
+		}
+
+		// Without its passphrase in vault the key could only be used locally
+		if err := vks.write(account.Address, map[string]interface{}{"passphrase": passphrase}); err != nil {
+			if deleteErr := vks.keystore.Delete(account, passphrase); deleteErr != nil {
+				log.Println("Error: removing keystore account", account.Address.Hex(), deleteErr)
+			}
+			return accounts.Account{}, err
+		}
+		return account, nil
+	}
+
+	account := accounts.Account{Address: address}
+	err := vks.write(account.Address, map[string]interface{}{"key": hex.EncodeToString(crypto.FromECDSA(key))})
+	return account, err
+}
+
+// Unlock is a no-op since vault accounts have no passphrase
+func (vks *vaultKeyStore) Unlock(account accounts.Account, passphrase string, _ time.Duration) error {
+	if passphrase != "" {
+		return ErrPassphraseUnsupported
+	}
+
+	if !vks.HasAddress(account.Address) {
+		return ErrAccountMissing
+	}
+
+	return nil
+}
+
+// Lock is a no-op since vault accounts have no passphrase
+func (vks *vaultKeyStore) Lock(_ ethCommon.Address) error {
+	return nil
+}
+
+// Accounts lists every account with an entry under the key path
+func (vks *vaultKeyStore) Accounts() ([]accounts.Account, error) {
+	secret, err := vks.client.Logical().List(vks.path)
+	if err != nil {
+		return nil, err
+	}
+
+	accs := []accounts.Account{}
+	if secret == nil {
+		return accs, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		address, ok := k.(string)
+		if !ok || !ethCommon.IsHexAddress(address) {
+			continue
+		}
+		accs = append(accs, accounts.Account{Address: ethCommon.HexToAddress(address)})
+	}
+
+	return accs, nil
+}
+
+// HasAddress reports whether a key for the given address is stored in vault
+func (vks *vaultKeyStore) HasAddress(address ethCommon.Address) bool {
+	secret, err := vks.read(address)
+	return err == nil && secret != nil
+}
+
+// SignTx loads the account's key from vault and signs the transaction with it
+func (vks *vaultKeyStore) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	data, err := vks.read(account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase, ok := data["passphrase"].(string); ok {
+		if vks.keystore == nil {
+			return nil, ErrKeystore
+		}
+		return vks.keystore.SignTxWithPassphrase(account, passphrase, tx, chainID)
+	}
+
+	key, err := vks.privateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if chainID != nil {
+		return types.SignTx(tx, types.NewEIP155Signer(chainID), key)
+	}
+	return types.SignTx(tx, types.HomesteadSigner{}, key)
+}
+
+// SignHashWithPassphrase is SignHash for an empty passphrase
+func (vks *vaultKeyStore) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	if passphrase != "" {
+		return nil, ErrPassphraseUnsupported
+	}
+
+	return vks.SignHash(account, hash)
+}
+
+// SignHash loads the account's key from vault and signs the hash with it.
+// The signature is in the [R || S || V] format where V is 0 or 1.
+func (vks *vaultKeyStore) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	data, err := vks.read(account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase, ok := data["passphrase"].(string); ok {
+		if vks.keystore == nil {
+			return nil, ErrKeystore
+		}
+		return vks.keystore.SignHashWithPassphrase(account, passphrase, hash)
+	}
+
+	key, err := vks.privateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.Sign(hash, key)
+}
+
+func (vks *vaultKeyStore) privateKey(data map[string]interface{}) (*ecdsa.PrivateKey, error) {
+	hexKey, ok := data["key"].(string)
+	if !ok {
+		return nil, ErrVaultKeyMalformed
+	}
+
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return nil, ErrVaultKeyMalformed
+	}
+
+	return key, nil
+}
+
+func (vks *vaultKeyStore) read(address ethCommon.Address) (map[string]interface{}, error) {
+	secret, err := vks.client.Logical().Read(vks.keyPath(address))
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil || secret.Data == nil {
+		return nil, ErrAccountMissing
+	}
+
+	return secret.Data, nil
+}
+
+func (vks *vaultKeyStore) write(address ethCommon.Address, data map[string]interface{}) error {
+	_, err := vks.client.Logical().Write(vks.keyPath(address), data)
+	return err
+}
+
+func createPassphrase() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// ErrPassphraseUnsupported is returned when a passphrase is given for a vault account
+var ErrPassphraseUnsupported = newRPCError(ErrCodeServer, "passphrases not supported by the vault signer")
+
+// ErrVaultKeyMalformed is returned when a vault entry holds neither a valid key nor a passphrase
+var ErrVaultKeyMalformed = errors.New("vault entry does not contain a valid key")
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eximchain/go-ethereum/accounts/keystore"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/eximchain/go-ethereum/crypto"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// fakeVault is an in-memory stand-in for the vault HTTP API. It supports
+// reading, writing, listing and deleting generic secrets under /v1/.
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	key := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+
+	switch {
+	case r.Method == "GET" && r.URL.Query().Get("list") == "true":
+		keys := []string{}
+		for k := range fv.secrets {
+			if strings.HasPrefix(k, key+"/") {
+				keys = append(keys, strings.TrimPrefix(k, key+"/"))
+			}
+		}
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.Method == "GET":
+		data, ok := fv.secrets[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case r.Method == "PUT" || r.Method == "POST":
+		var data map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fv.secrets[key] = data
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		delete(fv.secrets, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// NewFakeVault starts a fake vault server and returns a client connected to it
+func NewFakeVault(t *testing.T) (*vault.Client, func()) {
+	return newVaultServer(t, &fakeVault{secrets: make(map[string]map[string]interface{})})
+}
+
+// newVaultServer serves handler as vault and returns a client connected to it
+func newVaultServer(t *testing.T, handler http.Handler) (*vault.Client, func()) {
+	srv := httptest.NewServer(handler)
+
+	cfg := vault.DefaultConfig()
+	cfg.Address = srv.URL
+	cfg.MaxRetries = 0
+	client, err := vault.NewClient(cfg)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("cannot create vault client %s", err)
+	}
+	client.SetToken("test")
+
+	return client, srv.Close
+}
+
+func TestVaultKeyStore(t *testing.T) {
+	client, done := NewFakeVault(t)
+	defer done()
+
+	vks := newVaultKeyStore(client, "keys", nil)
+
+	account, err := vks.NewAccount("")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	if !vks.HasAddress(account.Address) {
+		t.Fatalf("account %s not stored in vault", account.Address.Hex())
+	}
+
+	accs, err := vks.Accounts()
+	if err != nil {
+		t.Fatalf("cannot list accounts %s", err)
+	}
+
+	if len(accs) != 1 || accs[0].Address != account.Address {
+		t.Fatalf("unexpected accounts %v", accs)
+	}
+
+	tx := types.NewTransaction(0, ethCommon.HexToAddress("0x1"), big.NewInt(1), 21000, big.NewInt(0), nil)
+	tx, err = vks.SignTx(account, tx, nil)
+	if err != nil {
+		t.Fatalf("cannot sign transaction %s", err)
+	}
+
+	sender, err := types.Sender(types.HomesteadSigner{}, tx)
+	if err != nil || sender != account.Address {
+		t.Fatalf("transaction signed by %s, expected %s", sender.Hex(), account.Address.Hex())
+	}
+
+	hash := signHash([]byte("hello"))
+	signature, err := vks.SignHash(account, hash)
+	if err != nil {
+		t.Fatalf("cannot sign hash %s", err)
+	}
+
+	pub, err := crypto.SigToPub(hash, signature)
+	if err != nil || crypto.PubkeyToAddress(*pub) != account.Address {
+		t.Fatalf("hash not signed by %s", account.Address.Hex())
+	}
+
+	missing := ethCommon.HexToAddress("0x2")
+	if vks.HasAddress(missing) {
+		t.Fatalf("unexpected account %s", missing.Hex())
+	}
+
+	// Passphrases are rejected rather than silently ignored
+	if _, err := vks.NewAccount("secret"); err != ErrPassphraseUnsupported {
+		t.Fatalf("expected %s creating an account, got %v", ErrPassphraseUnsupported, err)
+	}
+	key, _ := crypto.GenerateKey()
+	if _, err := vks.ImportKey(key, "secret"); err != ErrPassphraseUnsupported {
+		t.Fatalf("expected %s importing a key, got %v", ErrPassphraseUnsupported, err)
+	}
+	if err := vks.Unlock(account, "secret", 0); err != ErrPassphraseUnsupported {
+		t.Fatalf("expected %s unlocking, got %v", ErrPassphraseUnsupported, err)
+	}
+	if _, err := vks.SignHashWithPassphrase(account, "secret", hash); err != ErrPassphraseUnsupported {
+		t.Fatalf("expected %s signing, got %v", ErrPassphraseUnsupported, err)
+	}
+	if _, err := vks.SignHashWithPassphrase(account, "", hash); err != nil {
+		t.Fatalf("cannot sign without a passphrase %s", err)
+	}
+}
+
+func TestVaultKeyStorePassphrase(t *testing.T) {
+	client, done := NewFakeVault(t)
+	defer done()
+
+	dir, err := ioutil.TempDir("", "executor-keystore")
+	if err != nil {
+		t.Fatalf("cannot create keystore dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	vks := newVaultKeyStore(client, "keys", ks)
+
+	account, err := vks.NewAccount("")
+	if err != nil {
+		t.Fatalf("cannot create account %s", err)
+	}
+
+	data, err := vks.read(account.Address)
+	if err != nil {
+		t.Fatalf("cannot read vault entry %s", err)
+	}
+
+	if _, ok := data["key"]; ok {
+		t.Fatal("private key stored in vault")
+	}
+
+	tx := types.NewTransaction(0, ethCommon.HexToAddress("0x1"), big.NewInt(1), 21000, big.NewInt(0), nil)
+	tx, err = vks.SignTx(account, tx, nil)
+	if err != nil {
+		t.Fatalf("cannot sign transaction %s", err)
+	}
+
+	sender, err := types.Sender(types.HomesteadSigner{}, tx)
+	if err != nil || sender != account.Address {
+		t.Fatalf("transaction signed by %s, expected %s", sender.Hex(), account.Address.Hex())
+	}
+}
+
+func TestVaultKeyStoreWriteFailure(t *testing.T) {
+	fv := &fakeVault{secrets: make(map[string]map[string]interface{})}
+	client, done := newVaultServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" || r.Method == "POST" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fv.ServeHTTP(w, r)
+	}))
+	defer done()
+
+	dir, err := ioutil.TempDir("", "executor-keystore")
+	if err != nil {
+		t.Fatalf("cannot create keystore dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	vks := newVaultKeyStore(client, "keys", ks)
+
+	if _, err := vks.NewAccount(""); err == nil {
+		t.Fatal("expected the vault write to fail")
+	}
+
+	// The keystore must not keep a key that vault has no passphrase for
+	if accs := ks.Accounts(); len(accs) != 0 {
+		t.Fatalf("keystore account left behind %s", accs[0].Address.Hex())
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ethereum "github.com/eximchain/go-ethereum"
+	ethCommon "github.com/eximchain/go-ethereum/common"
+	"github.com/eximchain/go-ethereum/common/hexutil"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// How often the node's pending transaction filter is polled for
+// newPendingTransactions subscriptions
+const pendingTransactionPollInterval = time.Second
+
+// The default maximum number of subscriptions per websocket connection
+const defaultMaxSubscriptions = 100
+
+// wsConfig controls which pages may connect over websocket and how many
+// subscriptions a connection may hold
+type wsConfig struct {
+	// Origins allowed to connect, e.g. https://app.example.com; "*" allows
+	// any. Without any, only pages served from the executor's own host may
+	// connect. Clients that send no Origin are not browsers and are allowed.
+	origins []string
+	// Zero is unlimited
+	maxSubscriptions int
+}
+
+// allowOrigin reports whether a websocket upgrade may come from the request's Origin
+func (cfg wsConfig) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(cfg.origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range cfg.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MakeWSHandler serves the executor's JSON-RPC methods over websocket, plus
+// eth_subscribe and eth_unsubscribe
+func MakeWSHandler(svc transactionExecutorService, batchConcurrency int, cfg wsConfig) http.Handler {
+	m := makeEndpointCodecMap(svc)
+	h := makeBatchHandler(svc, m, batchConcurrency)
+
+	return websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			c := &wsConn{ws: ws, svc: svc, rpc: h, maxSubs: cfg.maxSubscriptions, subs: make(map[string]context.CancelFunc)}
+			c.serve()
+		},
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			if !cfg.allowOrigin(r) {
+				log.WithField("origin", r.Header.Get("Origin")).Warn("Websocket origin not allowed")
+				return errOriginNotAllowed
+			}
+			return nil
+		},
+	}
+}
+
+var errOriginNotAllowed = errors.New("origin not allowed")
+
+// wsConn holds the subscriptions of one websocket connection
+type wsConn struct {
+	ws      *websocket.Conn
+	svc     transactionExecutorService
+	rpc     *batchHandler
+	maxSubs int
+
+	writeMu sync.Mutex
+	subsMu  sync.Mutex
+	subs    map[string]context.CancelFunc
+}
+
+type wsNotification struct {
+	JSONRPC string               `json:"jsonrpc"`
+	Method  string               `json:"method"`
+	Params  wsNotificationParams `json:"params"`
+}
+
+type wsNotificationParams struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+func (c *wsConn) serve() {
+	// The upgrade request's context carries the authenticated user
+	ctx, cancel := context.WithCancel(c.ws.Request().Context())
+	defer cancel()
+	defer c.ws.Close()
+
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			return
+		}
+
+		trimmed := bytes.TrimLeft(msg, " \t\r\n")
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			c.serveBatch(ctx, msg)
+			continue
+		}
+
+		var req jsonrpc.Request
+		if err := json.Unmarshal(msg, &req); err != nil || req.Method == "" {
+			c.write(errorResponse(nil, jsonrpc.Error{Code: jsonrpc.InvalidRequestError, Message: "invalid request"}))
+			continue
+		}
+
+		if res := c.serveRequest(ctx, &req); req.ID != nil {
+			c.write(res)
+		}
+	}
+}
+
+func (c *wsConn) serveBatch(ctx context.Context, msg []byte) {
+	var reqs []json.RawMessage
+	if err := json.Unmarshal(msg, &reqs); err != nil || len(reqs) == 0 {
+		c.write(errorResponse(nil, jsonrpc.Error{Code: jsonrpc.InvalidRequestError, Message: "invalid batch"}))
+		return
+	}
+
+	if responses := c.rpc.serveBatch(ctx, reqs, c.serveRequest); len(responses) > 0 {
+		c.write(responses)
+	}
+}
+
+func (c *wsConn) serveRequest(ctx context.Context, req *jsonrpc.Request) *jsonrpc.Response {
+	switch req.Method {
+	case "eth_subscribe", "eth_unsubscribe":
+		if err := authorizeMethod(ctx, req.Method); err != nil {
+			return errorResponse(req.ID, rpcError(err))
+		}
+	default:
+		return c.rpc.serveRequest(ctx, req)
+	}
+
+	var result interface{}
+	var err error
+	if req.Method == "eth_subscribe" {
+		result, err = c.subscribe(ctx, req.Params)
+	} else {
+		result, err = c.unsubscribeRequest(req.Params)
+	}
+	logger := log.WithFields(log.Fields{"method": req.Method, "success": err == nil, "err": err})
+	logger.Info("RPC call served")
+	if err != nil {
+		return errorResponse(req.ID, rpcError(err))
+	}
+	return resultResponse(req.ID, result)
+}
+
+// unsubscribeRequest cancels the subscription named in eth_unsubscribe params
+func (c *wsConn) unsubscribeRequest(msg json.RawMessage) (bool, error) {
+	var params []string
+	if err := json.Unmarshal(msg, &params); err != nil || len(params) < 1 {
+		return false, invalidParams("expected a subscription id")
+	}
+
+	return c.unsubscribe(params[0]), nil
+}
+
+func (c *wsConn) subscribe(ctx context.Context, msg json.RawMessage) (string, error) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(msg, &params); err != nil || len(params) < 1 {
+		return "", ErrInvalidParams
+	}
+
+	var kind string
+	if err := json.Unmarshal(params[0], &kind); err != nil {
+		return "", ErrInvalidParams
+	}
+
+	// Requests on a connection, and subscriptions within a batch, are
+	// served one at a time, so the count cannot change before the
+	// subscription is added
+	c.subsMu.Lock()
+	full := c.maxSubs > 0 && len(c.subs) >= c.maxSubs
+	c.subsMu.Unlock()
+	if full {
+		return "", ErrTooManySubscriptions
+	}
+
+	id, err := newSubscriptionID()
+	if err != nil {
+		return "", err
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	client := c.svc.subscriptionClient()
+
+	switch kind {
+	case "newHeads":
+		heads := make(chan *types.Header)
+		sub, err := client.SubscribeNewHead(subCtx, heads)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		go c.forward(subCtx, id, sub, func() (interface{}, bool) {
+			select {
+			case head := <-heads:
+				return head, true
+			case <-subCtx.Done():
+				return nil, false
+			}
+		})
+	case "logs":
+		query := ethereum.FilterQuery{}
+		if len(params) > 1 {
+			query, err = parseFilterQuery(params[1])
+			if err != nil {
+				cancel()
+				return "", err
+			}
+		}
+		logs := make(chan types.Log)
+		sub, err := client.SubscribeFilterLogs(subCtx, query, logs)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		go c.forward(subCtx, id, sub, func() (interface{}, bool) {
+			select {
+			case l := <-logs:
+				return l, true
+			case <-subCtx.Done():
+				return nil, false
+			}
+		})
+	case "newPendingTransactions":
+		filter, err := c.svc.EthNewPendingTransactionFilter(subCtx, []interface{}{})
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		go c.pollPendingTransactions(subCtx, id, filter)
+	default:
+		cancel()
+		return "", ErrUnsupportedSubscription
+	}
+
+	c.subsMu.Lock()
+	c.subs[id] = cancel
+	c.subsMu.Unlock()
+
+	return id, nil
+}
+
+func (c *wsConn) unsubscribe(id string) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	cancel, present := c.subs[id]
+	if present {
+		cancel()
+		delete(c.subs, id)
+	}
+
+	return present
+}
+
+// forward sends each value from next to the client until the subscription
+// ends or next reports it is done
+func (c *wsConn) forward(ctx context.Context, id string, sub ethereum.Subscription, next func() (interface{}, bool)) {
+	defer sub.Unsubscribe()
+
+	go func() {
+		select {
+		case err := <-sub.Err():
+			if err != nil {
+				log.WithField("subscription", id).Warn("Node subscription ended: ", err)
+			}
+			c.unsubscribe(id)
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		v, ok := next()
+		if !ok {
+			return
+		}
+		c.notify(id, v)
+	}
+}
+
+func (c *wsConn) pollPendingTransactions(ctx context.Context, id string, filter interface{}) {
+	ticker := time.NewTicker(pendingTransactionPollInterval)
+	defer ticker.Stop()
+	defer c.svc.EthUninstallFilter(context.Background(), []interface{}{filter})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := c.svc.EthGetFilterChanges(ctx, []interface{}{filter})
+			if err != nil {
+				log.WithField("subscription", id).Warn("Pending transaction filter failed: ", err)
+				c.unsubscribe(id)
+				return
+			}
+
+			hashes, _ := res.([]interface{})
+			for _, hash := range hashes {
+				c.notify(id, hash)
+			}
+		}
+	}
+}
+
+func (c *wsConn) notify(id string, result interface{}) {
+	c.write(wsNotification{
+		JSONRPC: jsonrpc.Version,
+		Method:  "eth_subscription",
+		Params:  wsNotificationParams{Subscription: id, Result: result},
+	})
+}
+
+func (c *wsConn) write(v interface{}) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := websocket.JSON.Send(c.ws, v); err != nil {
+		log.Println("websocket write error", err)
+	}
+}
+
+func resultResponse(id *jsonrpc.RequestID, result interface{}) *jsonrpc.Response {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(id, rpcError(err))
+	}
+
+	return &jsonrpc.Response{JSONRPC: jsonrpc.Version, Result: b, ID: id}
+}
+
+func newSubscriptionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hexutil.Encode(b), nil
+}
+
+// parseFilterQuery reads the address and topics of an eth_subscribe logs
+// filter. Block ranges do not apply to subscriptions and are ignored.
+func parseFilterQuery(msg json.RawMessage) (ethereum.FilterQuery, error) {
+	var raw struct {
+		Address json.RawMessage `json:"address"`
+		Topics  []interface{}   `json:"topics"`
+	}
+	query := ethereum.FilterQuery{}
+
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return query, ErrInvalidParams
+	}
+
+	if len(raw.Address) > 0 && string(raw.Address) != "null" {
+		var addresses []string
+		if err := json.Unmarshal(raw.Address, &addresses); err != nil {
+			var address string
+			if err := json.Unmarshal(raw.Address, &address); err != nil {
+				return query, ErrInvalidParams
+			}
+			addresses = []string{address}
+		}
+
+		for _, address := range addresses {
+			if !ethCommon.IsHexAddress(address) {
+				return query, ErrInvalidParams
+			}
+			query.Addresses = append(query.Addresses, ethCommon.HexToAddress(address))
+		}
+	}
+
+	for _, topic := range raw.Topics {
+		switch t := topic.(type) {
+		case nil:
+			query.Topics = append(query.Topics, nil)
+		case string:
+			query.Topics = append(query.Topics, []ethCommon.Hash{ethCommon.HexToHash(t)})
+		case []interface{}:
+			hashes := []ethCommon.Hash{}
+			for _, h := range t {
+				s, ok := h.(string)
+				if !ok {
+					return query, ErrInvalidParams
+				}
+				hashes = append(hashes, ethCommon.HexToHash(s))
+			}
+			query.Topics = append(query.Topics, hashes)
+		default:
+			return query, ErrInvalidParams
+		}
+	}
+
+	return query, nil
+}
+
+// ErrUnsupportedSubscription is returned for unknown eth_subscribe types
+var ErrUnsupportedSubscription = newRPCError(jsonrpc.InvalidParamsError, "unsupported subscription type")
+
+// ErrTooManySubscriptions is returned when a connection holds the maximum number of subscriptions
+var ErrTooManySubscriptions = newRPCError(ErrCodeLimitExceeded, "too many subscriptions")
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eximchain/eth-client/quorum"
+	ethereum "github.com/eximchain/go-ethereum"
+	"github.com/eximchain/go-ethereum/core/types"
+	"github.com/eximchain/go-ethereum/event"
+	"golang.org/x/net/websocket"
+)
+
+// fakeHeadSubscriber hands new head subscriptions to the test
+type fakeHeadSubscriber struct {
+	quorum.Client
+
+	subscribed chan chan<- *types.Header
+}
+
+func (s *fakeHeadSubscriber) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	s.subscribed <- ch
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	}), nil
+}
+
+type testWSMessage struct {
+	testRPCResponse
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func readWS(t *testing.T, ws *websocket.Conn) testWSMessage {
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg testWSMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("cannot read websocket message %s", err)
+	}
+
+	return msg
+}
+
+func TestWebsocketSubscription(t *testing.T) {
+	svc, _ := NewTestService()
+	subscriber := &fakeHeadSubscriber{subscribed: make(chan chan<- *types.Header, 1)}
+	svc.subscriber = subscriber
+
+	srv := httptest.NewServer(MakeWSHandler(svc, 2, wsConfig{}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("cannot dial websocket %s", err)
+	}
+	defer ws.Close()
+
+	// Ordinary methods are served over the socket too
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":1,"method":"personal_newAccount","params":[""]}`)
+	if msg := readWS(t, ws); msg.Error != nil || len(msg.Result) != 44 {
+		t.Fatalf("unexpected response %+v", msg)
+	}
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["unknown"]}`)
+	if msg := readWS(t, ws); msg.Error == nil {
+		t.Fatalf("expected unsupported subscription error, got %+v", msg)
+	}
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["newHeads"]}`)
+	msg := readWS(t, ws)
+	var id string
+	if err := json.Unmarshal(msg.Result, &id); err != nil || id == "" {
+		t.Fatalf("expected subscription id, got %+v", msg)
+	}
+
+	heads := <-subscriber.subscribed
+	heads <- &types.Header{Number: big.NewInt(42), Difficulty: big.NewInt(1), Time: big.NewInt(1)}
+
+	msg = readWS(t, ws)
+	if msg.Method != "eth_subscription" || msg.Params.Subscription != id {
+		t.Fatalf("unexpected notification %+v", msg)
+	}
+	var head types.Header
+	if err := json.Unmarshal(msg.Params.Result, &head); err != nil || head.Number.Int64() != 42 {
+		t.Fatalf("unexpected head %s %s", msg.Params.Result, err)
+	}
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":4,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	if msg := readWS(t, ws); string(msg.Result) != "true" {
+		t.Fatalf("expected unsubscribe to succeed, got %+v", msg)
+	}
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":5,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	if msg := readWS(t, ws); string(msg.Result) != "false" {
+		t.Fatalf("expected second unsubscribe to fail, got %+v", msg)
+	}
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	svc, _ := NewTestService()
+	wsURL := func(srv *httptest.Server) string { return "ws" + strings.TrimPrefix(srv.URL, "http") }
+
+	srv := httptest.NewServer(MakeWSHandler(svc, 2, wsConfig{}))
+	defer srv.Close()
+
+	if _, err := websocket.Dial(wsURL(srv), "", "https://evil.example.com"); err == nil {
+		t.Fatal("expected a foreign origin to be rejected")
+	}
+
+	allowed := httptest.NewServer(MakeWSHandler(svc, 2, wsConfig{origins: []string{"https://app.example.com/"}}))
+	defer allowed.Close()
+
+	ws, err := websocket.Dial(wsURL(allowed), "", "https://app.example.com")
+	if err != nil {
+		t.Fatalf("expected an allowed origin to connect, got %s", err)
+	}
+	ws.Close()
+
+	if _, err := websocket.Dial(wsURL(allowed), "", allowed.URL); err == nil {
+		t.Fatal("expected the executor's own origin to need listing once origins are set")
+	}
+}
+
+func TestWebsocketSubscriptionLimit(t *testing.T) {
+	svc, _ := NewTestService()
+	svc.subscriber = &fakeHeadSubscriber{subscribed: make(chan chan<- *types.Header, 2)}
+
+	srv := httptest.NewServer(MakeWSHandler(svc, 2, wsConfig{maxSubscriptions: 1}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("cannot dial websocket %s", err)
+	}
+	defer ws.Close()
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]}`)
+	msg := readWS(t, ws)
+	var id string
+	if err := json.Unmarshal(msg.Result, &id); err != nil || id == "" {
+		t.Fatalf("expected subscription id, got %+v", msg)
+	}
+
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":2,"method":"eth_subscribe","params":["newHeads"]}`)
+	if msg := readWS(t, ws); msg.Error == nil || msg.Error.Code != ErrCodeLimitExceeded {
+		t.Fatalf("expected too many subscriptions, got %+v", msg)
+	}
+
+	// Unsubscribing frees the slot
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":3,"method":"eth_unsubscribe","params":["`+id+`"]}`)
+	readWS(t, ws)
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":4,"method":"eth_subscribe","params":["newHeads"]}`)
+	if msg := readWS(t, ws); msg.Error != nil {
+		t.Fatalf("expected a new subscription, got %+v", msg)
+	}
+}
+
+func TestWebsocketUnsubscribeChecks(t *testing.T) {
+	svc, _ := NewTestService()
+	svc.subscriber = &fakeHeadSubscriber{subscribed: make(chan chan<- *types.Header, 1)}
+
+	user := User{Email: "wsunsubscribe@example.com", Role: RoleReadOnly, ReadRate: 1}
+	h := MakeWSHandler(svc, 2, wsConfig{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), permissionsContextKey, user)))
+	}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("cannot dial websocket %s", err)
+	}
+	defer ws.Close()
+
+	// Unsubscribing counts towards the read rate like any other read
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":1,"method":"eth_unsubscribe","params":["0x1"]}`)
+	if msg := readWS(t, ws); string(msg.Result) != "false" {
+		t.Fatalf("expected an unknown subscription, got %+v", msg)
+	}
+	websocket.Message.Send(ws, `{"jsonrpc":"2.0","id":2,"method":"eth_unsubscribe","params":["0x1"]}`)
+	if msg := readWS(t, ws); msg.Error == nil || msg.Error.Code != ErrCodeLimitExceeded {
+		t.Fatalf("expected the read rate to apply, got %+v", msg)
+	}
+}
+
+func TestWebsocketBatch(t *testing.T) {
+	svc, _ := NewTestService()
+	svc.subscriber = &fakeHeadSubscriber{subscribed: make(chan chan<- *types.Header, 1)}
+
+	srv := httptest.NewServer(MakeWSHandler(svc, 2, wsConfig{}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("cannot dial websocket %s", err)
+	}
+	defer ws.Close()
+
+	websocket.Message.Send(ws, `[{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newHeads"]},{"jsonrpc":"2.0","id":2,"method":"personal_newAccount","params":[""]},{"jsonrpc":"2.0","id":3,"method":"eth_unsubscribe","params":[]}]`)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var responses []testRPCResponse
+	if err := websocket.JSON.Receive(ws, &responses); err != nil {
+		t.Fatalf("cannot read batch response %s", err)
+	}
+	if len(responses) != 3 || responses[0].Error != nil || responses[1].Error != nil || len(responses[1].Result) != 44 {
+		t.Fatalf("unexpected batch responses %+v", responses)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != -32602 {
+		t.Fatalf("expected invalid params for eth_unsubscribe, got %+v", responses[2])
+	}
+}
+
+func TestParseFilterQuery(t *testing.T) {
+	query, err := parseFilterQuery(json.RawMessage(`{"address":"0x0000000000000000000000000000000000000010","topics":[null,"0x01",["0x02","0x03"]]}`))
+	if err != nil {
+		t.Fatalf("cannot parse filter %s", err)
+	}
+
+	if len(query.Addresses) != 1 || len(query.Topics) != 3 || query.Topics[0] != nil || len(query.Topics[2]) != 2 {
+		t.Fatalf("unexpected query %+v", query)
+	}
+
+	if _, err := parseFilterQuery(json.RawMessage(`{"address":"nope"}`)); err != ErrInvalidParams {
+		t.Fatalf("expected invalid params, got %v", err)
+	}
+}